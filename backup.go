@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultBackupTemplate names backups <src>.<unix-ts>.bak
+const defaultBackupTemplate = "{name}.{ts}.bak"
+
+// backupSuffix ends every backup name, so pruning could never take an ordinary file for a backup
+const backupSuffix = ".bak"
+
+// backupOptions controls where and how backups of in-place fixes are made
+type backupOptions struct {
+	disabled bool
+	dir      string // empty means next to the source
+	mirror   bool   // mirror the source directory tree inside dir
+	template string // backup file name, {name} is replaced with a source file name and {ts} with a unix timestamp
+}
+
+// backupFile is a backup made by an in-place fix
+type backupFile struct {
+	Path string
+	Name string // source file base name
+	Time time.Time
+}
+
+// validate checks that backups could be found by their names later
+func (b backupOptions) validate() error {
+	template := b.nameTemplate()
+	if !strings.Contains(template, "{name}") || !strings.Contains(template, "{ts}") {
+		return fmt.Errorf("backup name template %s must contain both {name} and {ts}", template)
+	}
+	if !strings.HasSuffix(template, backupSuffix) {
+		return fmt.Errorf("backup name template %s must end with %s", template, backupSuffix)
+	}
+	if strings.ContainsRune(template, filepath.Separator) {
+		return fmt.Errorf("backup name template %s must not contain directories", template)
+	}
+	if b.mirror && b.dir == "" {
+		return fmt.Errorf("mirrored backups need a backup directory")
+	}
+	return nil
+}
+
+func (b backupOptions) nameTemplate() string {
+	if b.template == "" {
+		return defaultBackupTemplate
+	}
+	return b.template
+}
+
+// dirFor returns a directory where backups of src go
+func (b backupOptions) dirFor(src string) (string, error) {
+	if b.dir == "" {
+		return filepath.Dir(src), nil
+	}
+	if !b.mirror {
+		return b.dir, nil
+	}
+	abs, err := filepath.Abs(src)
+	if err != nil {
+		return "", err
+	}
+	// strip volume name and root, so the tree could be recreated inside the backup directory
+	rel := strings.TrimPrefix(abs[len(filepath.VolumeName(abs)):], string(filepath.Separator))
+	return filepath.Join(b.dir, filepath.Dir(rel)), nil
+}
+
+// path returns a backup file name of src made at a given time
+func (b backupOptions) path(src string, t time.Time) (string, error) {
+	dir, err := b.dirFor(src)
+	if err != nil {
+		return "", err
+	}
+	replacer := strings.NewReplacer("{name}", filepath.Base(src), "{ts}", strconv.FormatInt(t.Unix(), 10))
+	return filepath.Join(dir, replacer.Replace(b.nameTemplate())), nil
+}
+
+// namePattern matches backup file names, capturing a source file name and a timestamp
+func (b backupOptions) namePattern() *regexp.Regexp {
+	pattern := regexp.QuoteMeta(b.nameTemplate())
+	pattern = strings.Replace(pattern, regexp.QuoteMeta("{name}"), "(?P<name>.+)", 1)
+	pattern = strings.Replace(pattern, regexp.QuoteMeta("{ts}"), "(?P<ts>[0-9]+)", 1)
+	return regexp.MustCompile("^" + pattern + "$")
+}
+
+// parse recognizes a backup by its file name
+func (b backupOptions) parse(name string) (backupFile, bool) {
+	pattern := b.namePattern()
+	m := pattern.FindStringSubmatch(filepath.Base(name))
+	if m == nil {
+		return backupFile{}, false
+	}
+	ts, err := strconv.ParseInt(m[pattern.SubexpIndex("ts")], 10, 64)
+	if err != nil {
+		return backupFile{}, false
+	}
+	return backupFile{Path: name, Name: m[pattern.SubexpIndex("name")], Time: time.Unix(ts, 0)}, true
+}
+
+// find lists backups of src, oldest first
+func (b backupOptions) find(src string) ([]backupFile, error) {
+	dir, err := b.dirFor(src)
+	if err != nil {
+		return nil, err
+	}
+	replacer := strings.NewReplacer("{name}", escapeGlob(filepath.Base(src)), "{ts}", "*")
+	names, err := filepath.Glob(filepath.Join(escapeGlob(dir), replacer.Replace(escapeGlob(b.nameTemplate()))))
+	if err != nil {
+		return nil, err
+	}
+	backups := []backupFile{}
+	for _, name := range names {
+		if backup, ok := b.parse(name); ok && backup.Name == filepath.Base(src) {
+			backups = append(backups, backup)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Time.Before(backups[j].Time)
+	})
+	return backups, nil
+}
+
+// escapeGlob escapes glob meta characters in a file name
+func escapeGlob(name string) string {
+	replacer := strings.NewReplacer(`*`, `\*`, `?`, `\?`, `[`, `\[`, `\`, `\\`)
+	return replacer.Replace(name)
+}
+
+// makeBackup copies src to a new backup and makes sure it is byte-identical to the source. The backup gets
+// permission bits of the source, and the other attributes as well if preserve is set
+func (b backupOptions) makeBackup(src string, t time.Time, preserve bool) (string, error) {
+	backup, err := b.path(src, t)
+	if err != nil {
+		return "", err
+	}
+	if ok, _ := fileExists(backup); ok {
+		return "", fmt.Errorf("backup %s already exists", backup)
+	}
+	if err := os.MkdirAll(filepath.Dir(backup), 0755); err != nil {
+		return "", err
+	}
+	if err := copyFileContents(src, backup); err != nil {
+		return "", err
+	}
+	same, err := sameFileContents(src, backup)
+	if err != nil {
+		return "", err
+	}
+	if !same {
+		return "", fmt.Errorf("backup %s differs from the source", backup)
+	}
+	if err := writeChecksum(backup); err != nil {
+		return "", fmt.Errorf("failed writing backup checksum: %w", err)
+	}
+	if err := applyFileAttrs(src, backup, preserve); err != nil {
+		return "", fmt.Errorf("failed carrying file attributes over to backup: %w", err)
+	}
+	return backup, nil
+}
+
+// checksumPath returns a name of a file holding the sha256 checksum of a backup
+func checksumPath(backup string) string {
+	return backup + ".sha256"
+}
+
+// writeChecksum records the sha256 checksum of a backup in sha256sum format
+func writeChecksum(backup string) error {
+	hash, err := fileHash(backup)
+	if err != nil {
+		return err
+	}
+	line := fmt.Sprintf("%s  %s\n", hex.EncodeToString(hash), filepath.Base(backup))
+	return os.WriteFile(checksumPath(backup), []byte(line), 0644)
+}
+
+// verifyChecksum checks a backup against the checksum recorded when it was made. Backups made by older
+// versions have no checksum, they are only reported
+func verifyChecksum(backup string) error {
+	data, err := os.ReadFile(checksumPath(backup))
+	if errors.Is(err, os.ErrNotExist) {
+		log.Warn().Msgf("Backup %s has no checksum, its contents are not verified", backup)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed reading backup checksum: %w", err)
+	}
+	expected, _, _ := strings.Cut(strings.TrimSpace(string(data)), " ")
+	hash, err := fileHash(backup)
+	if err != nil {
+		return fmt.Errorf("failed reading backup: %w", err)
+	}
+	if !strings.EqualFold(expected, hex.EncodeToString(hash)) {
+		return fmt.Errorf("backup %s does not match its checksum", backup)
+	}
+	return nil
+}
+
+// removeBackup removes a backup along with its checksum
+func removeBackup(backup string) error {
+	if err := os.Remove(backup); err != nil {
+		return err
+	}
+	if err := os.Remove(checksumPath(backup)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
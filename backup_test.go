@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupOptionsPath(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+
+	actual, err := backupOptions{}.path("/music/a.mp3", ts)
+	assert.NoError(t, err)
+	assert.Equal(t, "/music/a.mp3.1700000000.bak", actual, "should keep backups next to the source by default")
+
+	actual, err = backupOptions{dir: "/backups", template: "{ts}-{name}.bak"}.path("/music/a.mp3", ts)
+	assert.NoError(t, err)
+	assert.Equal(t, "/backups/1700000000-a.mp3.bak", actual)
+
+	actual, err = backupOptions{dir: "/backups", mirror: true}.path("/music/rock/a.mp3", ts)
+	assert.NoError(t, err)
+	assert.Equal(t, "/backups/music/rock/a.mp3.1700000000.bak", actual, "should mirror the source tree")
+}
+
+func TestBackupOptionsValidate(t *testing.T) {
+	assert.NoError(t, backupOptions{}.validate())
+	assert.EqualError(t, backupOptions{template: "{name}.bak"}.validate(),
+		"backup name template {name}.bak must contain both {name} and {ts}")
+	assert.EqualError(t, backupOptions{template: "{name}-{ts}"}.validate(),
+		"backup name template {name}-{ts} must end with .bak", "should not take ordinary files for backups")
+	assert.EqualError(t, backupOptions{mirror: true}.validate(), "mirrored backups need a backup directory")
+}
+
+func TestBackupOptionsParse(t *testing.T) {
+	b, ok := backupOptions{}.parse("/music/a.b.mp3.1700000000.bak")
+	assert.True(t, ok)
+	assert.Equal(t, "a.b.mp3", b.Name)
+	assert.Equal(t, int64(1700000000), b.Time.Unix())
+
+	_, ok = backupOptions{}.parse("/music/a.mp3.bak")
+	assert.False(t, ok, "should require a timestamp")
+
+	b, ok = backupOptions{template: "{ts}-{name}.bak"}.parse("/backups/1700000000-a.mp3.bak")
+	assert.True(t, ok)
+	assert.Equal(t, "a.mp3", b.Name)
+}
+
+func TestMakeBackup(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "music", "a.mp3")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(src), 0755))
+	assert.NoError(t, os.WriteFile(src, []byte("data"), 0644))
+	opts := backupOptions{dir: filepath.Join(dir, "backups"), mirror: true}
+
+	backup, err := opts.makeBackup(src, time.Unix(100, 0), true)
+	assert.NoError(t, err)
+	data, err := os.ReadFile(backup)
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+
+	found, err := opts.find(src)
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, backup, found[0].Path)
+
+	_, err = opts.makeBackup(src, time.Unix(100, 0), true)
+	assert.EqualError(t, err, "backup "+backup+" already exists")
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// fixResult is an outcome of fixing a single file of a batch
+type fixResult struct {
+	src    string
+	report FileReport
+	err    error
+	logs   *bytes.Buffer // buffered log output, nil if logged directly
+}
+
+// fixBatch fixes sources in-place with a pool of jobs workers. Log output of every file is grouped
+// and printed in the order of sources. Unless forced, the first error cancels the rest of the batch.
+// File reports are added to reports, if given
+func fixBatch(sources []string, opts fixOptions, jobs int, reports *reportWriter) (fixedCnt, errCnt int) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// every file gets its own channel, so results could be consumed in order
+	results := make([]chan fixResult, len(sources))
+	for i := range results {
+		results[i] = make(chan fixResult, 1)
+	}
+
+	queue := make(chan int)
+	go func() {
+		defer close(queue)
+		for i := range sources {
+			select {
+			case queue <- i:
+			case <-ctx.Done():
+				results[i] <- fixResult{src: sources[i], err: ctx.Err()}
+			}
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				if err := ctx.Err(); err != nil {
+					results[i] <- fixResult{src: sources[i], err: err}
+					continue
+				}
+				res := fixOne(ctx, sources[i], opts, jobs > 1)
+				if res.err != nil && !opts.forced {
+					// cancel as soon as possible, so no other file gets fixed
+					cancel()
+				}
+				results[i] <- res
+			}
+		}()
+	}
+
+	aborted := false
+	for i := range sources {
+		res := <-results[i]
+		if res.logs != nil {
+			logOutput.Write(res.logs.Bytes())
+		}
+		if reports != nil {
+			if res.report.File == "" {
+				// skipped before fixing even started
+				res.report = FileReport{File: res.src, Errors: []string{res.err.Error()}}
+			}
+			if err := reports.Add(res.report); err != nil {
+				log.Error().Err(err).Msg("Failed writing report")
+			}
+		}
+		if errors.Is(res.err, context.Canceled) {
+			log.Debug().Msgf("Skipped %s", res.src)
+			continue
+		}
+		if res.err != nil {
+			errCnt += 1
+			if !opts.forced && !aborted {
+				log.Error().Msg("Aborting...")
+				aborted = true
+			}
+			continue
+		}
+		fixedCnt += 1
+		if opts.dryRun && (reports == nil || !reports.lines) {
+			printChanges(res.src, res.report.Changes)
+		}
+	}
+	wg.Wait()
+
+	return fixedCnt, errCnt
+}
+
+// fixOne fixes a single file in-place, buffering its log output if needed
+func fixOne(ctx context.Context, src string, opts fixOptions, buffered bool) fixResult {
+	res := fixResult{src: src}
+	logger := log.Logger
+	if buffered {
+		res.logs = &bytes.Buffer{}
+		logger = newLogger(res.logs)
+	}
+
+	logger.Info().Msgf("Fixing %s...", src)
+	res.report, res.err = fixMp3(logger.WithContext(ctx), src, "", opts)
+	if res.err != nil && !errors.Is(res.err, context.Canceled) {
+		logError(logger, res.err)
+	}
+
+	return res
+}
+
+// logError logs an error along with the unwrapped one
+func logError(logger zerolog.Logger, err error) {
+	logger.Error().Err(err).Msg("")
+	// for debug purposes
+	if unwrapped := errors.Unwrap(err); unwrapped != nil {
+		logger.Error().Err(unwrapped).Msg("Unwrapped error")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/bogem/id3v2/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixBatch(t *testing.T) {
+	goldenFile := "testdata/podenelnik-id3v2.mp3"
+	checkV2GoldenFileIntegrity(t, goldenFile)
+
+	dir := t.TempDir()
+	sources := []string{}
+	for i := 0; i < 5; i++ {
+		src := filepath.Join(dir, fmt.Sprintf("%d.mp3", i))
+		assert.NoError(t, copyFileContents(goldenFile, src))
+		sources = append(sources, src)
+	}
+
+	fixedCnt, errCnt := fixBatch(sources, fixOptions{frames: supportedV2Frames(), forced: true}, 3, nil)
+	assert.Equal(t, 5, fixedCnt)
+	assert.Equal(t, 0, errCnt)
+	for _, src := range sources {
+		tag, err := id3v2.Open(src, id3v2.Options{Parse: true})
+		assert.NoError(t, err)
+		assert.Equal(t, "РАО Говорящая книга", tag.GetTextFrame("TENC").Text)
+		tag.Close()
+	}
+}
+
+func TestFixBatch_Abort(t *testing.T) {
+	goldenFile := "testdata/podenelnik-id3v2.mp3"
+	checkV2GoldenFileIntegrity(t, goldenFile)
+
+	dir := t.TempDir()
+	sources := []string{}
+	for i := 0; i < 4; i++ {
+		src := filepath.Join(dir, fmt.Sprintf("%d.mp3", i))
+		assert.NoError(t, copyFileContents(goldenFile, src))
+		// TALB holds raw cp1251 bytes, which are not valid utf8, so fixing it fails
+		tag, err := id3v2.Open(src, id3v2.Options{Parse: true})
+		assert.NoError(t, err)
+		tag.AddTextFrame("TALB", id3v2.EncodingUTF8, "\xd1\xe0\xe9\xf2")
+		assert.NoError(t, tag.Save())
+		tag.Close()
+		sources = append(sources, src)
+	}
+	opts := fixOptions{frames: map[string]string{"Album/Movie/Show title": "TALB"}}
+	fixedCnt, errCnt := fixBatch(sources, opts, 1, nil)
+	assert.Equal(t, 0, fixedCnt)
+	assert.Equal(t, 1, errCnt, "should abort on the first error")
+
+	sources = append([]string{filepath.Join(dir, "missing.mp3")}, sources...)
+	opts = fixOptions{frames: supportedV2Frames(), forced: true}
+	fixedCnt, errCnt = fixBatch(sources, opts, 2, nil)
+	assert.Equal(t, 4, fixedCnt)
+	assert.Equal(t, 1, errCnt, "should not abort when forced")
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// SubFrame is a frame embedded into CHAP or CTOC frame. Frames which can not be fixed are kept as
+// id3v2.UnknownFrame
+type SubFrame struct {
+	ID    string
+	Flags [2]byte
+	Frame id3v2.Framer
+}
+
+// ChapterFrame is a CHAP frame, see https://id3.org/id3v2-chapters-1.0. Unlike the library one, it keeps all
+// the sub-frames and writes their headers according to the tag version
+type ChapterFrame struct {
+	Version     byte
+	ElementID   string
+	StartTime   uint32 // milliseconds
+	EndTime     uint32 // milliseconds
+	StartOffset uint32
+	EndOffset   uint32
+	SubFrames   []SubFrame
+}
+
+func (f ChapterFrame) body() []byte {
+	data := bytes.Buffer{}
+	data.Write(latin1Bytes(f.ElementID))
+	data.WriteByte(0)
+	binary.Write(&data, binary.BigEndian, []uint32{f.StartTime, f.EndTime, f.StartOffset, f.EndOffset})
+	writeSubFrames(&data, f.SubFrames, f.Version)
+	return data.Bytes()
+}
+
+func (f ChapterFrame) Size() int {
+	return len(f.body())
+}
+
+func (f ChapterFrame) UniqueIdentifier() string {
+	return f.ElementID
+}
+
+func (f ChapterFrame) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(f.body())
+	return int64(n), err
+}
+
+// TableOfContentsFrame is a CTOC frame, see https://id3.org/id3v2-chapters-1.0
+type TableOfContentsFrame struct {
+	Version   byte
+	ElementID string
+	Flags     byte // top-level and ordered bits
+	ChildIDs  []string
+	SubFrames []SubFrame
+}
+
+func (f TableOfContentsFrame) body() []byte {
+	data := bytes.Buffer{}
+	data.Write(latin1Bytes(f.ElementID))
+	data.WriteByte(0)
+	data.WriteByte(f.Flags)
+	data.WriteByte(byte(len(f.ChildIDs)))
+	for _, id := range f.ChildIDs {
+		data.Write(latin1Bytes(id))
+		data.WriteByte(0)
+	}
+	writeSubFrames(&data, f.SubFrames, f.Version)
+	return data.Bytes()
+}
+
+func (f TableOfContentsFrame) Size() int {
+	return len(f.body())
+}
+
+func (f TableOfContentsFrame) UniqueIdentifier() string {
+	return f.ElementID
+}
+
+func (f TableOfContentsFrame) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(f.body())
+	return int64(n), err
+}
+
+func writeSubFrames(w *bytes.Buffer, subFrames []SubFrame, version byte) {
+	for _, sub := range subFrames {
+		body := bytes.Buffer{}
+		sub.Frame.WriteTo(&body)
+		w.WriteString(sub.ID)
+		if version == 4 {
+			w.Write(intToSynchsafe(body.Len()))
+		} else {
+			binary.Write(w, binary.BigEndian, uint32(body.Len()))
+		}
+		w.Write(sub.Flags[:])
+		body.WriteTo(w)
+	}
+}
+
+// parseChapterFrame parses CHAP frame body
+func parseChapterFrame(body []byte, version byte) (ChapterFrame, error) {
+	id, rest, ok := bytes.Cut(body, []byte{0})
+	if !ok || len(rest) < 16 {
+		return ChapterFrame{}, errors.New("chapter frame is too short")
+	}
+	subFrames, err := parseSubFrames(rest[16:], version)
+	if err != nil {
+		return ChapterFrame{}, err
+	}
+	return ChapterFrame{
+		Version:     version,
+		ElementID:   string(id),
+		StartTime:   binary.BigEndian.Uint32(rest[0:4]),
+		EndTime:     binary.BigEndian.Uint32(rest[4:8]),
+		StartOffset: binary.BigEndian.Uint32(rest[8:12]),
+		EndOffset:   binary.BigEndian.Uint32(rest[12:16]),
+		SubFrames:   subFrames,
+	}, nil
+}
+
+// parseTableOfContentsFrame parses CTOC frame body
+func parseTableOfContentsFrame(body []byte, version byte) (TableOfContentsFrame, error) {
+	id, rest, ok := bytes.Cut(body, []byte{0})
+	if !ok || len(rest) < 2 {
+		return TableOfContentsFrame{}, errors.New("table of contents frame is too short")
+	}
+	frame := TableOfContentsFrame{Version: version, ElementID: string(id), Flags: rest[0]}
+	count := int(rest[1])
+	rest = rest[2:]
+	for i := 0; i < count; i++ {
+		var child []byte
+		child, rest, ok = bytes.Cut(rest, []byte{0})
+		if !ok {
+			return TableOfContentsFrame{}, errors.New("table of contents child id went over frame")
+		}
+		frame.ChildIDs = append(frame.ChildIDs, string(child))
+	}
+	subFrames, err := parseSubFrames(rest, version)
+	if err != nil {
+		return TableOfContentsFrame{}, err
+	}
+	frame.SubFrames = subFrames
+	return frame, nil
+}
+
+func parseSubFrames(data []byte, version byte) ([]SubFrame, error) {
+	frames, err := parseRawFrames(data, version)
+	if err != nil {
+		return nil, err
+	}
+	subFrames := make([]SubFrame, 0, len(frames))
+	for _, f := range frames {
+		sub := SubFrame{ID: f.ID, Flags: f.Flags, Frame: id3v2.UnknownFrame{Body: f.Body}}
+		if !f.formatted() {
+			sub.Frame = parseSubFrame(f.ID, f.Body)
+		}
+		subFrames = append(subFrames, sub)
+	}
+	return subFrames, nil
+}
+
+// parseSubFrame parses text and link sub-frames, which are used for chapter titles and links
+func parseSubFrame(id string, body []byte) id3v2.Framer {
+	if len(body) > 0 && id[0] == 'T' {
+		encoding := encodingByKey(body[0])
+		if id == "TXXX" {
+			desc, value := splitText(body[1:], encoding)
+			return id3v2.UserDefinedTextFrame{
+				Encoding:    encoding,
+				Description: decodeText(desc, encoding),
+				Value:       decodeText(value, encoding),
+			}
+		}
+		return id3v2.TextFrame{Encoding: encoding, Text: decodeText(body[1:], encoding)}
+	}
+	if frame, ok := parseURLFrame(id, body); ok {
+		return frame
+	}
+	return id3v2.UnknownFrame{Body: body}
+}
+
+// rawFrame is an id3v2.3 or id3v2.4 frame as it is stored in a file
+type rawFrame struct {
+	ID      string
+	Flags   [2]byte
+	Body    []byte
+	Version byte
+}
+
+// formatted checks if frame body is compressed, encrypted, unsynchronised or prefixed with a group id
+func (f rawFrame) formatted() bool {
+	if f.Version == 4 {
+		return f.Flags[1]&0x4f != 0
+	}
+	return f.Flags[1]&0xe0 != 0
+}
+
+func parseRawFrames(data []byte, version byte) ([]rawFrame, error) {
+	frames := []rawFrame{}
+	for len(data) >= 10 && data[0] != 0 {
+		var size int
+		if version == 4 {
+			size = synchsafeToInt(data[4:8])
+		} else {
+			size = int(binary.BigEndian.Uint32(data[4:8]))
+		}
+		if 10+size > len(data) {
+			return nil, fmt.Errorf("frame %s went over tag area", data[0:4])
+		}
+		frames = append(frames, rawFrame{
+			ID:      string(data[0:4]),
+			Flags:   [2]byte{data[8], data[9]},
+			Body:    data[10 : 10+size],
+			Version: version,
+		})
+		data = data[10+size:]
+	}
+	return frames, nil
+}
+
+// readRawV2Frames reads frames of an id3v2.3 or id3v2.4 tag, the library does not provide raw frames
+func readRawV2Frames(fileName string) ([]rawFrame, error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(fh, header); err != nil {
+		return nil, err
+	}
+	version := header[3]
+	if string(header[0:3]) != "ID3" || (version != 3 && version != 4) {
+		return nil, errors.New("not an id3v2.3 or id3v2.4 tag")
+	}
+	if header[5]&0x80 != 0 {
+		return nil, errors.New("unsynchronised tags are not supported")
+	}
+	data := make([]byte, synchsafeToInt(header[6:10]))
+	if _, err := io.ReadFull(fh, data); err != nil {
+		return nil, err
+	}
+	if header[5]&0x40 != 0 && len(data) >= 4 {
+		// extended header
+		if version == 4 {
+			data = data[min(synchsafeToInt(data[0:4]), len(data)):]
+		} else {
+			data = data[min(4+int(binary.BigEndian.Uint32(data[0:4])), len(data)):]
+		}
+	}
+	return parseRawFrames(data, version)
+}
+
+// replaceChapterFrames replaces CHAP and CTOC frames parsed by the library, which drops all sub-frames but
+// TIT2 and TIT3, with the lossless ones
+func replaceChapterFrames(tag *id3v2.Tag, fileName string) error {
+	if len(tag.GetFrames("CHAP")) == 0 && len(tag.GetFrames("CTOC")) == 0 {
+		return nil
+	}
+	frames, err := readRawV2Frames(fileName)
+	if err != nil {
+		return err
+	}
+	chapters := []id3v2.Framer{}
+	tocs := []id3v2.Framer{}
+	for _, f := range frames {
+		if f.ID != "CHAP" && f.ID != "CTOC" {
+			continue
+		}
+		if f.formatted() {
+			return fmt.Errorf("compressed, encrypted, unsynchronised or grouped %s frames are not supported", f.ID)
+		}
+		if f.ID == "CHAP" {
+			frame, err := parseChapterFrame(f.Body, f.Version)
+			if err != nil {
+				return err
+			}
+			chapters = append(chapters, frame)
+		} else {
+			frame, err := parseTableOfContentsFrame(f.Body, f.Version)
+			if err != nil {
+				return err
+			}
+			tocs = append(tocs, frame)
+		}
+	}
+	tag.DeleteFrames("CHAP")
+	tag.DeleteFrames("CTOC")
+	for _, f := range chapters {
+		tag.AddFrame("CHAP", f)
+	}
+	for _, f := range tocs {
+		tag.AddFrame("CTOC", f)
+	}
+	return nil
+}
+
+// fixSubFrames fixes text and link sub-frames with fixV2Frame, other sub-frames are kept as is. Changes are
+// reported as <sub-frame id>.<field>, with an index for repeated sub-frames
+func fixSubFrames(subFrames []SubFrame, dec decoding, enc id3v2.Encoding, normalizeURLs bool) ([]SubFrame, map[string]Change, error) {
+	fixed := make([]SubFrame, 0, len(subFrames))
+	changes := make(map[string]Change)
+	seen := make(map[string]int)
+	for _, sub := range subFrames {
+		prefix := sub.ID
+		if n := seen[sub.ID]; n > 0 {
+			prefix = fmt.Sprintf("%s[%d]", sub.ID, n)
+		}
+		seen[sub.ID] += 1
+		if _, ok := sub.Frame.(id3v2.UnknownFrame); ok {
+			fixed = append(fixed, sub)
+			continue
+		}
+		frame, fixes, err := fixV2Frame(sub.Frame, dec, enc, normalizeURLs)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", prefix, err)
+		}
+		if fixes != nil {
+			sub.Frame = frame
+			for field, change := range fixes {
+				changes[prefix+"."+field] = change
+			}
+		}
+		fixed = append(fixed, sub)
+	}
+	if len(changes) == 0 {
+		return nil, nil, nil
+	}
+	return fixed, changes, nil
+}
+
+// subFramesWithVersion converts encodings of sub-frames for the given tag version
+func subFramesWithVersion(subFrames []SubFrame, version byte) []SubFrame {
+	converted := make([]SubFrame, 0, len(subFrames))
+	for _, sub := range subFrames {
+		if version == 3 {
+			sub.Frame, _ = withV23Encoding(sub.Frame)
+		}
+		converted = append(converted, sub)
+	}
+	return converted
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bogem/id3v2/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixMp3_Chapters(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "fixed.mp3")
+	assert.NoError(t, copyFileContents("testdata/podenelnik-id3v2.mp3", dst))
+
+	tag, err := id3v2.Open(dst, id3v2.Options{Parse: true})
+	assert.NoError(t, err)
+	version := tag.Version()
+	unknown := SubFrame{ID: "XYZW", Frame: id3v2.UnknownFrame{Body: []byte{1, 2, 3}}}
+	tag.AddFrame("CHAP", ChapterFrame{Version: version, ElementID: "ch1", StartTime: 0, EndTime: 70000,
+		StartOffset: 0xffffffff, EndOffset: 0xffffffff, SubFrames: []SubFrame{
+			{ID: "TIT2", Frame: id3v2.TextFrame{Encoding: id3v2.EncodingISO, Text: latin1("\xc3\xeb\xe0\xe2\xe0 1")}},
+			unknown,
+		}})
+	tag.AddFrame("CTOC", TableOfContentsFrame{Version: version, ElementID: "toc", Flags: 3, ChildIDs: []string{"ch1"},
+		SubFrames: []SubFrame{
+			{ID: "TIT2", Frame: id3v2.TextFrame{Encoding: id3v2.EncodingISO, Text: latin1("\xce\xe3\xeb\xe0\xe2\xeb\xe5\xed\xe8\xe5")}},
+		}})
+	assert.NoError(t, tag.Save())
+	tag.Close()
+
+	opts := fixOptions{frames: map[string]string{"CHAP": "CHAP", "CTOC": "CTOC"}}
+	report, err := fixMp3(context.Background(), dst, "", opts)
+	assert.NoError(t, err)
+	assert.Empty(t, report.Errors)
+	assert.Len(t, report.Changes, 2)
+
+	frames, err := readRawV2Frames(dst)
+	assert.NoError(t, err)
+	chapters := map[string]id3v2.Framer{}
+	for _, f := range frames {
+		switch f.ID {
+		case "CHAP":
+			chapters[f.ID], err = parseChapterFrame(f.Body, f.Version)
+			assert.NoError(t, err)
+		case "CTOC":
+			chapters[f.ID], err = parseTableOfContentsFrame(f.Body, f.Version)
+			assert.NoError(t, err)
+		}
+	}
+	enc := textEncoding(version)
+	assert.Equal(t, ChapterFrame{Version: version, ElementID: "ch1", StartTime: 0, EndTime: 70000,
+		StartOffset: 0xffffffff, EndOffset: 0xffffffff, SubFrames: []SubFrame{
+			{ID: "TIT2", Frame: id3v2.TextFrame{Encoding: enc, Text: "Глава 1"}},
+			unknown,
+		}}, chapters["CHAP"])
+	assert.Equal(t, TableOfContentsFrame{Version: version, ElementID: "toc", Flags: 3, ChildIDs: []string{"ch1"},
+		SubFrames: []SubFrame{
+			{ID: "TIT2", Frame: id3v2.TextFrame{Encoding: enc, Text: "Оглавление"}},
+		}}, chapters["CTOC"])
+}
+
+func TestFixMp3_ChaptersGrouped(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "fixed.mp3")
+	assert.NoError(t, copyFileContents("testdata/podenelnik-id3v2.mp3", dst))
+
+	tag, err := id3v2.Open(dst, id3v2.Options{Parse: true})
+	assert.NoError(t, err)
+	// grouping identity flag of id3v2.4, the body starts with a group id
+	grouped := SubFrame{ID: "TIT2", Flags: [2]byte{0, 0x40},
+		Frame: id3v2.UnknownFrame{Body: []byte("\x01\x00\xc3\xeb\xe0\xe2\xe0")}}
+	tag.AddFrame("CHAP", ChapterFrame{Version: 4, ElementID: "ch1", EndTime: 70000,
+		StartOffset: 0xffffffff, EndOffset: 0xffffffff, SubFrames: []SubFrame{grouped}})
+	assert.NoError(t, tag.Save())
+	tag.Close()
+
+	report, err := fixMp3(context.Background(), dst, "", fixOptions{frames: map[string]string{"CHAP": "CHAP"}})
+	assert.NoError(t, err)
+	assert.Empty(t, report.Changes, "should copy grouped sub-frames as is")
+
+	frames, err := readRawV2Frames(dst)
+	assert.NoError(t, err)
+	for _, f := range frames {
+		if f.ID == "CHAP" {
+			chapter, err := parseChapterFrame(f.Body, f.Version)
+			assert.NoError(t, err)
+			assert.Equal(t, []SubFrame{grouped}, chapter.SubFrames)
+		}
+	}
+}
+
+func TestFixMp3_ChaptersNotSelected(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "fixed.mp3")
+	assert.NoError(t, copyFileContents("testdata/podenelnik-id3v2.mp3", dst))
+
+	tag, err := id3v2.Open(dst, id3v2.Options{Parse: true})
+	assert.NoError(t, err)
+	tag.AddFrame("CHAP", ChapterFrame{Version: 4, ElementID: "ch1", EndTime: 70000,
+		StartOffset: 0xffffffff, EndOffset: 0xffffffff})
+	assert.NoError(t, tag.Save())
+	tag.Close()
+	// mark the chapter frame compressed, so it could not be read
+	data, err := os.ReadFile(dst)
+	assert.NoError(t, err)
+	i := bytes.Index(data, []byte("CHAP"))
+	assert.Greater(t, i, 0)
+	data[i+9] |= 0x08
+	assert.NoError(t, os.WriteFile(dst, data, 0644))
+
+	report, err := fixMp3(context.Background(), dst, "", fixOptions{frames: map[string]string{"Album/Movie/Show title": "TALB"}})
+	assert.NoError(t, err, "should not fail on chapters which are not fixed")
+	assert.Empty(t, report.Errors)
+
+	_, err = fixMp3(context.Background(), dst, "", fixOptions{frames: map[string]string{"CHAP": "CHAP"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// textEncoding returns the encoding fixed text is written in. There is no utf8 in id3v2.3
+func textEncoding(version byte) id3v2.Encoding {
+	if version == 4 {
+		return id3v2.EncodingUTF8
+	}
+	return id3v2.EncodingUTF16
+}
+
+// id3v2.4 frames which have no id3v2.3 counterpart, they are dropped from id3v2.3 tags
+var v24OnlyFrames = []string{"TDEN", "TDRL", "TDTG", "TMOO", "TPRO", "TSOA", "TSOP", "TSOT", "TSST"}
+
+// id3v2.3 frames which have no id3v2.4 counterpart, they are dropped from id3v2.4 tags
+var v23OnlyFrames = []string{"TRDA", "TSIZ"}
+
+// convertTagVersion sets the version of a tag, converting frames which differ between id3v2.3 and id3v2.4:
+// date frames, involved people lists and text encodings not allowed in id3v2.3. Frames which can not be
+// converted are dropped
+func convertTagVersion(tag *id3v2.Tag, version byte) {
+	tag.SetVersion(version)
+	if version == 4 {
+		if people := involvedPeople(tag, "IPLS"); len(people) > 0 {
+			tag.AddTextFrame("TIPL", id3v2.EncodingUTF8, strings.Join(people, "\x00"))
+		}
+		tag.DeleteFrames("IPLS")
+		dropFrames(tag, v23OnlyFrames)
+		year, date, hour := tag.GetTextFrame("TYER"), tag.GetTextFrame("TDAT"), tag.GetTextFrame("TIME")
+		if tdrc := v23DateToTDRC(year.Text, date.Text, hour.Text); tdrc != "" {
+			tag.AddTextFrame("TDRC", id3v2.EncodingISO, tdrc)
+		}
+		tag.DeleteFrames("TYER")
+		tag.DeleteFrames("TDAT")
+		tag.DeleteFrames("TIME")
+		if tory := tag.GetTextFrame("TORY"); tory.Text != "" {
+			tag.AddTextFrame("TDOR", id3v2.EncodingISO, tory.Text)
+		}
+		tag.DeleteFrames("TORY")
+		convertFrames(tag, version)
+		return
+	}
+
+	if tdrc := tag.GetTextFrame("TDRC"); tdrc.Text != "" {
+		year, date, hour := tdrcToV23Date(tdrc.Text)
+		for id, text := range map[string]string{"TYER": year, "TDAT": date, "TIME": hour} {
+			if text != "" {
+				tag.AddTextFrame(id, id3v2.EncodingISO, text)
+			}
+		}
+	}
+	tag.DeleteFrames("TDRC")
+	if tdor := tag.GetTextFrame("TDOR"); tdor.Text != "" {
+		year, _, _ := tdrcToV23Date(tdor.Text)
+		tag.AddTextFrame("TORY", id3v2.EncodingISO, year)
+	}
+	tag.DeleteFrames("TDOR")
+	// id3v2.3 has a single list for both involved people and musicians
+	if people := append(involvedPeople(tag, "TIPL"), involvedPeople(tag, "TMCL")...); len(people) > 0 {
+		tag.AddFrame("IPLS", id3v2.UnknownFrame{Body: textListBody(people, id3v2.EncodingUTF16)})
+	}
+	tag.DeleteFrames("TIPL")
+	tag.DeleteFrames("TMCL")
+	dropFrames(tag, v24OnlyFrames)
+	convertFrames(tag, version)
+}
+
+// dropFrames removes frames which can not be converted to the tag version
+func dropFrames(tag *id3v2.Tag, ids []string) {
+	for _, id := range ids {
+		if n := len(tag.GetFrames(id)); n > 0 {
+			log.Warn().Msgf("Dropped %d %s frame(s), not supported by id3v2.%d", n, id, tag.Version())
+			tag.DeleteFrames(id)
+		}
+	}
+}
+
+// involvedPeople returns role and name pairs of a TIPL, TMCL or IPLS frame, flattened
+func involvedPeople(tag *id3v2.Tag, id string) []string {
+	people := []string{}
+	for _, f := range tag.GetFrames(id) {
+		var values []string
+		switch v := f.(type) {
+		case id3v2.TextFrame:
+			values = strings.Split(v.Text, "\x00")
+		case id3v2.UnknownFrame:
+			values = parseTextList(v.Body)
+		}
+		for _, value := range values {
+			people = append(people, strings.TrimPrefix(value, "\ufeff"))
+		}
+	}
+	// a trailing terminator leaves an empty value
+	if len(people)%2 == 1 && people[len(people)-1] == "" {
+		people = people[:len(people)-1]
+	}
+	return people
+}
+
+// convertFrames converts encodings of frames, not allowed in id3v2.3, and sub-frames of chapters
+func convertFrames(tag *id3v2.Tag, version byte) {
+	for id, frames := range tag.AllFrames() {
+		converted := make([]id3v2.Framer, 0, len(frames))
+		changed := false
+		for _, f := range frames {
+			ok := false
+			switch v := f.(type) {
+			case ChapterFrame:
+				v.Version = version
+				v.SubFrames = subFramesWithVersion(v.SubFrames, version)
+				f, ok = v, true
+			case TableOfContentsFrame:
+				v.Version = version
+				v.SubFrames = subFramesWithVersion(v.SubFrames, version)
+				f, ok = v, true
+			default:
+				if version == 3 {
+					f, ok = withV23Encoding(f)
+				}
+			}
+			changed = changed || ok
+			converted = append(converted, f)
+		}
+		if changed {
+			tag.DeleteFrames(id)
+			for _, f := range converted {
+				tag.AddFrame(id, f)
+			}
+		}
+	}
+}
+
+// withV23Encoding replaces id3v2.4 only encodings of a frame with utf16. Returns true if the frame was changed
+func withV23Encoding(f id3v2.Framer) (id3v2.Framer, bool) {
+	invalid := func(enc id3v2.Encoding) bool {
+		return enc.Equals(id3v2.EncodingUTF8) || enc.Equals(id3v2.EncodingUTF16BE)
+	}
+	switch v := f.(type) {
+	case id3v2.TextFrame:
+		if invalid(v.Encoding) {
+			v.Encoding = id3v2.EncodingUTF16
+			return v, true
+		}
+	case id3v2.UserDefinedTextFrame:
+		if invalid(v.Encoding) {
+			v.Encoding = id3v2.EncodingUTF16
+			return v, true
+		}
+	case id3v2.CommentFrame:
+		if invalid(v.Encoding) {
+			v.Encoding = id3v2.EncodingUTF16
+			return v, true
+		}
+	case id3v2.UnsynchronisedLyricsFrame:
+		if invalid(v.Encoding) {
+			v.Encoding = id3v2.EncodingUTF16
+			return v, true
+		}
+	case SynchronisedLyricsFrame:
+		if invalid(v.Encoding) {
+			v.Encoding = id3v2.EncodingUTF16
+			return v, true
+		}
+	case GeneralEncapsulatedObjectFrame:
+		if invalid(v.Encoding) {
+			v.Encoding = id3v2.EncodingUTF16
+			return v, true
+		}
+	case UserDefinedURLFrame:
+		if invalid(v.Encoding) {
+			v.Encoding = id3v2.EncodingUTF16
+			return v, true
+		}
+	case id3v2.PictureFrame:
+		if invalid(v.Encoding) {
+			v.Encoding = id3v2.EncodingUTF16
+			return v, true
+		}
+	}
+	return f, false
+}
+
+// v23DateToTDRC merges id3v2.3 TYER (YYYY), TDAT (DDMM) and TIME (HHMM) into id3v2.4 TDRC timestamp
+func v23DateToTDRC(year, date, hour string) string {
+	if len(year) != 4 {
+		return year
+	}
+	res := year
+	if len(date) == 4 {
+		res += "-" + date[2:4] + "-" + date[0:2]
+		if len(hour) == 4 {
+			res += "T" + hour[0:2] + ":" + hour[2:4]
+		}
+	}
+	return res
+}
+
+// tdrcToV23Date splits id3v2.4 timestamp (yyyy-MM-ddTHH:mm:ss, any part but the year may be missing) into
+// id3v2.3 TYER, TDAT and TIME values. Parts which can not be represented in id3v2.3 are empty
+func tdrcToV23Date(tdrc string) (year, date, hour string) {
+	day, clock, _ := strings.Cut(tdrc, "T")
+	parts := strings.Split(day, "-")
+	year = parts[0]
+	if len(parts) < 3 {
+		return year, "", ""
+	}
+	date = parts[2] + parts[1]
+	clockParts := strings.Split(clock, ":")
+	if len(clockParts) >= 2 {
+		hour = clockParts[0] + clockParts[1]
+	}
+	return year, date, hour
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/bogem/id3v2/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestV23DateToTDRC(t *testing.T) {
+	assert.Equal(t, "", v23DateToTDRC("", "", ""))
+	assert.Equal(t, "2005", v23DateToTDRC("2005", "", "1230"))
+	assert.Equal(t, "2005-03-01", v23DateToTDRC("2005", "0103", ""))
+	assert.Equal(t, "2005-03-01T12:30", v23DateToTDRC("2005", "0103", "1230"))
+}
+
+func TestTdrcToV23Date(t *testing.T) {
+	for _, tc := range []struct {
+		tdrc, year, date, hour string
+	}{
+		{"2005", "2005", "", ""},
+		{"2005-03", "2005", "", ""},
+		{"2005-03-01", "2005", "0103", ""},
+		{"2005-03-01T12", "2005", "0103", ""},
+		{"2005-03-01T12:30:15", "2005", "0103", "1230"},
+	} {
+		year, date, hour := tdrcToV23Date(tc.tdrc)
+		assert.Equal(t, []string{tc.year, tc.date, tc.hour}, []string{year, date, hour}, tc.tdrc)
+	}
+}
+
+func TestConvertTagVersion(t *testing.T) {
+	tag := id3v2.NewEmptyTag()
+	tag.AddTextFrame("TIT2", id3v2.EncodingUTF8, "Понедельник")
+	tag.AddTextFrame("TDRC", id3v2.EncodingUTF8, "2005-03-01T12:30")
+	tag.AddCommentFrame(id3v2.CommentFrame{Encoding: id3v2.EncodingUTF8, Language: "rus", Text: "Книга"})
+
+	convertTagVersion(tag, 3)
+	assert.Equal(t, byte(3), tag.Version())
+	assert.Equal(t, id3v2.EncodingUTF16, tag.GetTextFrame("TIT2").Encoding)
+	assert.Equal(t, id3v2.EncodingUTF16, tag.GetFrames("COMM")[0].(id3v2.CommentFrame).Encoding)
+	assert.Empty(t, tag.GetFrames("TDRC"))
+	assert.Equal(t, "2005", tag.GetTextFrame("TYER").Text)
+	assert.Equal(t, "0103", tag.GetTextFrame("TDAT").Text)
+	assert.Equal(t, "1230", tag.GetTextFrame("TIME").Text)
+
+	convertTagVersion(tag, 4)
+	assert.Equal(t, byte(4), tag.Version())
+	assert.Equal(t, "2005-03-01T12:30", tag.GetTextFrame("TDRC").Text)
+	assert.Empty(t, tag.GetFrames("TYER"))
+	assert.Empty(t, tag.GetFrames("TDAT"))
+	assert.Empty(t, tag.GetFrames("TIME"))
+}
+
+// frameIDs lists ids of frames as they are written in a file
+func frameIDs(t *testing.T, fileName string) map[string]bool {
+	frames, err := readRawV2Frames(fileName)
+	assert.NoError(t, err)
+	ids := map[string]bool{}
+	for _, f := range frames {
+		ids[f.ID] = true
+	}
+	return ids
+}
+
+func TestConvertTagVersion_Frames(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "fixed.mp3")
+	assert.NoError(t, copyFileContents("testdata/podenelnik-id3v2.mp3", dst))
+
+	tag, err := id3v2.Open(dst, id3v2.Options{Parse: true})
+	assert.NoError(t, err)
+	tag.DeleteFrames("TDRC")
+	tag.AddTextFrame("TDRC", id3v2.EncodingUTF8, "2005-03")
+	tag.AddTextFrame("TIPL", id3v2.EncodingUTF8, "producer\x00Иванов")
+	tag.AddTextFrame("TMCL", id3v2.EncodingUTF8, "guitar\x00Петров")
+	for _, id := range v24OnlyFrames {
+		tag.AddTextFrame(id, id3v2.EncodingUTF8, "2005")
+	}
+	convertTagVersion(tag, 3)
+	assert.NoError(t, tag.Save())
+	tag.Close()
+
+	ids := frameIDs(t, dst)
+	assert.True(t, ids["IPLS"])
+	assert.True(t, ids["TYER"], "should keep the year of a date without a day")
+	for _, id := range append([]string{"TIPL", "TMCL", "TDRC"}, v24OnlyFrames...) {
+		assert.False(t, ids[id], id)
+	}
+
+	tag, err = id3v2.Open(dst, id3v2.Options{Parse: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "2005", tag.GetTextFrame("TYER").Text)
+	assert.Equal(t, []string{"producer", "Иванов", "guitar", "Петров"},
+		parseTextList(tag.GetLastFrame("IPLS").(id3v2.UnknownFrame).Body))
+	tag.AddTextFrame("TRDA", id3v2.EncodingUTF16, "March 2005")
+	tag.AddTextFrame("TSIZ", id3v2.EncodingUTF16, "1000")
+	convertTagVersion(tag, 4)
+	assert.NoError(t, tag.Save())
+	tag.Close()
+
+	ids = frameIDs(t, dst)
+	assert.True(t, ids["TIPL"])
+	assert.True(t, ids["TDRC"])
+	for _, id := range []string{"IPLS", "TRDA", "TSIZ", "TYER"} {
+		assert.False(t, ids[id], id)
+	}
+	tag, err = id3v2.Open(dst, id3v2.Options{Parse: true})
+	assert.NoError(t, err)
+	defer tag.Close()
+	assert.Equal(t, "producer\x00Иванов\x00guitar\x00Петров", tag.GetTextFrame("TIPL").Text)
+}
+
+func TestFixMp3_TargetVersion(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "fixed.mp3")
+	opts := fixOptions{frames: supportedV2Frames(), forced: true, targetVersion: 3}
+	report, err := fixMp3(context.Background(), "testdata/podenelnik-id3v2.mp3", dst, opts)
+	assert.NoError(t, err)
+	for _, c := range report.Changes {
+		assert.Equal(t, id3v2.EncodingUTF16.Key, c.NewEncoding)
+	}
+
+	tag, err := id3v2.Open(dst, id3v2.Options{Parse: true})
+	assert.NoError(t, err)
+	defer tag.Close()
+	assert.Equal(t, byte(3), tag.Version())
+	assert.Equal(t, "Понедельник начинается в субботу", tag.Album())
+	assert.Equal(t, id3v2.EncodingUTF16, tag.GetTextFrame("TALB").Encoding)
+	assert.Equal(t, "2005", tag.GetTextFrame("TYER").Text)
+	assert.Empty(t, tag.GetFrames("TDRC"))
+}
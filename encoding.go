@@ -1,74 +1,642 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 
-	translit "github.com/essentialkaos/translit/v3"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/charmap"
 )
 
-// brokenCp1251ToUtf8 re-encodes 1-byte cp1251, erroneously encoded as a 2-byte string, back to a valid utf8
-func brokenCp1251ToUtf8(s string) (string, error) {
+// charset is a single-byte code page the original tag could have been written in
+type charset struct {
+	Name     string
+	Encoding encoding.Encoding
+}
+
+// charsetGuess is the result of detecting the most plausible code page of a string
+type charsetGuess struct {
+	Charset    charset
+	Confidence float64 // 0..1, how much the best candidate outscores the others
+}
+
+// registry of supported source code pages
+var charsets = []charset{
+	{"cp1251", charmap.Windows1251},
+	{"koi8-r", charmap.KOI8R},
+	{"koi8-u", charmap.KOI8U},
+	{"cp866", charmap.CodePage866},
+	{"cp855", charmap.CodePage855},
+	{"iso-8859-5", charmap.ISO8859_5},
+	{"mac-cyrillic", charmap.MacintoshCyrillic},
+	{"cp1250", charmap.Windows1250},
+	{"iso-8859-2", charmap.ISO8859_2},
+	{"cp1252", charmap.Windows1252},
+	{"cp1253", charmap.Windows1253},
+	{"iso-8859-7", charmap.ISO8859_7},
+	{"cp1254", charmap.Windows1254},
+	{"cp1257", charmap.Windows1257},
+}
+
+// candidate code pages for detection, the first one wins on a tie (e.g. pure ascii)
+var detectableCharsets = []string{"cp1251", "koi8-r", "cp866", "iso-8859-5"}
+
+// minimum cyrillicScore of a detected code page. Text scoring less is likely western text in cp1252, e.g. with
+// accented letters or curly quotes, which any cyrillic code page turns into rubbish
+const minDetectScore = 0.5
+
+// cp1251, the most common code page of broken tags, is kept unless another one outscores it by this margin.
+// Short names like "Цой" often look a bit more plausible in a wrong code page
+const detectMargin = 1.0
+
+// ukrainian and belarusian letters, which are plausible in cyrillic text along with russian ones
+const slavicLetters = "іїєґў"
+
+// code page assumed when no cyrillic one is plausible, it leaves latin1 and cp1252 text as it is
+var fallbackCharset = charset{"cp1252", charmap.Windows1252}
+
+// lookupCharset finds a code page in the registry by its name, windows-125X aliases are accepted too
+func lookupCharset(name string) (charset, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.Replace(name, "windows-", "cp", 1)
+	for _, cs := range charsets {
+		if cs.Name == name {
+			return cs, nil
+		}
+	}
+	return charset{}, fmt.Errorf("charset %s not supported", name)
+}
+
+// charsetNames lists names of all supported code pages
+func charsetNames() []string {
+	res := make([]string, 0, len(charsets))
+	for _, cs := range charsets {
+		res = append(res, cs.Name)
+	}
+	return res
+}
+
+// relative frequencies (%) of russian letters in a typical text
+var cyrillicLetterFreq = map[rune]float64{
+	'о': 10.97, 'е': 8.45, 'а': 8.01, 'и': 7.35, 'н': 6.70, 'т': 6.26, 'с': 5.47, 'р': 4.73,
+	'в': 4.54, 'л': 4.40, 'к': 3.49, 'м': 3.21, 'д': 2.98, 'п': 2.81, 'у': 2.62, 'я': 2.01,
+	'ы': 1.90, 'ь': 1.74, 'г': 1.70, 'з': 1.65, 'б': 1.59, 'ч': 1.44, 'й': 1.21, 'х': 0.97,
+	'ж': 0.94, 'ш': 0.73, 'ю': 0.64, 'ц': 0.48, 'щ': 0.36, 'э': 0.32, 'ф': 0.26, 'ъ': 0.04,
+	'ё': 0.04,
+}
+
+// most common russian bigrams
+var cyrillicBigrams = map[string]bool{
+	"ст": true, "но": true, "то": true, "на": true, "ен": true, "ов": true, "ни": true,
+	"ра": true, "во": true, "ко": true, "ро": true, "ал": true, "пр": true, "ре": true,
+	"ть": true, "ла": true, "ли": true, "ор": true, "по": true, "не": true, "ет": true,
+	"го": true, "ка": true, "ос": true, "ес": true, "ан": true, "он": true, "ло": true,
+}
+
+// textClass is a kind of text found in a tag
+type textClass int
+
+const (
+	textASCII       textClass = iota // nothing to fix
+	textUnicode                      // valid text beyond latin1, e.g. cyrillic written by a correct tagger
+	textMojibake                     // 1-byte text, erroneously decoded as latin1 or cp1252
+	textUndecodable                  // invalid utf8 or replacement characters
+)
+
+var textClassNames = map[textClass]string{
+	textASCII:       "ascii",
+	textUnicode:     "unicode",
+	textMojibake:    "mojibake",
+	textUndecodable: "undecodable",
+}
+
+func (c textClass) String() string {
+	return textClassNames[c]
+}
+
+var errUndecodable = errors.New("undecodable text")
+
+// classifyText tells valid text, which must be left as is, from mojibake, which can be fixed, and from
+// undecodable data. Text consisting of latin1 and cp1252 runes only is considered mojibake
+func classifyText(s string) textClass {
+	if !utf8.ValidString(s) {
+		return textUndecodable
+	}
+	class := textASCII
+	for _, r := range s {
+		switch {
+		case r == utf8.RuneError:
+			return textUndecodable
+		case r < utf8.RuneSelf:
+		case r <= 0xff:
+			if class == textASCII {
+				class = textMojibake
+			}
+		default:
+			if _, ok := charmap.Windows1252.EncodeRune(r); ok {
+				if class == textASCII {
+					class = textMojibake
+				}
+			} else {
+				class = textUnicode
+			}
+		}
+	}
+	return class
+}
+
+// decoding tells how broken text is restored
+type decoding struct {
+	charset *charset        // source code page of 1-byte text, nil means autodetect
+	chains  []manglingChain // mangling chains to try, only 1byte-latin1 if empty
+}
+
+// manglingStep undoes a single erroneous decoding of text
+type manglingStep func(s string, cs *charset) (string, charsetGuess, error)
+
+// manglingChain is a sequence of erroneous decodings text went through. It is named after the encodings
+// text was written and misread in, e.g. utf8-cp1251 is utf8 misread as cp1251
+type manglingChain struct {
+	Name  string
+	Steps []manglingStep // in the order they are undone
+}
+
+// registry of supported mangling chains, the first one is the classic 1-byte text misread as latin1
+var manglingChains = []manglingChain{
+	{"1byte-latin1", []manglingStep{undo1byteAsLatin1}},
+	{"utf8-latin1", []manglingStep{undoUtf8AsLatin1}},
+	{"utf8-cp1251", []manglingStep{undoUtf8AsCp1251}},
+	{"1byte-latin1-utf8-latin1", []manglingStep{undoUtf8AsLatin1, undo1byteAsLatin1}},
+	{"utf8-latin1-utf8-latin1", []manglingStep{undoUtf8AsLatin1, undoUtf8AsLatin1}},
+	{"utf8-cp1251-utf8-cp1251", []manglingStep{undoUtf8AsCp1251, undoUtf8AsCp1251}},
+}
+
+// lookupManglingChain finds a mangling chain in the registry by its name
+func lookupManglingChain(name string) (manglingChain, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, chain := range manglingChains {
+		if chain.Name == name {
+			return chain, nil
+		}
+	}
+	return manglingChain{}, fmt.Errorf("mangling chain %s not supported", name)
+}
+
+// manglingChainNames lists names of all supported mangling chains
+func manglingChainNames() []string {
+	res := make([]string, 0, len(manglingChains))
+	for _, chain := range manglingChains {
+		res = append(res, chain.Name)
+	}
+	return res
+}
+
+// undo reverses all the steps of the chain, the guess of the last step is returned
+func (c manglingChain) undo(s string, cs *charset) (string, charsetGuess, error) {
+	var guess charsetGuess
+	for _, step := range c.Steps {
+		var err error
+		s, guess, err = step(s, cs)
+		if err != nil {
+			return "", charsetGuess{}, err
+		}
+	}
+	return s, guess, nil
+}
+
+// undo1byteAsLatin1 restores 1-byte text in a given code page, misread as latin1 or cp1252.
+// The code page is detected when cs is nil
+func undo1byteAsLatin1(s string, cs *charset) (string, charsetGuess, error) {
+	if cs != nil {
+		res, err := brokenCp1251ToUtf8(s, *cs)
+		if err != nil {
+			return "", charsetGuess{}, err
+		}
+		return res, charsetGuess{Charset: *cs, Confidence: 1}, nil
+	}
+
+	raw, err := mojibakeBytes(s)
+	if err != nil {
+		return "", charsetGuess{}, err
+	}
+	guess := detectCharset(raw)
+	log.Trace().Msgf("Detected charset %s (confidence %.2f)", guess.Charset.Name, guess.Confidence)
+
+	res, err := guess.Charset.Encoding.NewDecoder().Bytes(raw)
+	if err != nil {
+		return "", charsetGuess{}, err
+	}
+	log.Trace().Msg("1byte->utf8:\n" + formatBytes(string(res)))
+
+	return string(res), guess, nil
+}
+
+// undoUtf8AsLatin1 restores utf8 text misread as latin1 or cp1252
+func undoUtf8AsLatin1(s string, _ *charset) (string, charsetGuess, error) {
+	raw, err := mojibakeBytes(s)
+	if err != nil {
+		return "", charsetGuess{}, err
+	}
+	if !utf8.Valid(raw) {
+		return "", charsetGuess{}, errors.New("not an utf8 misread as latin1")
+	}
+	return string(raw), charsetGuess{Charset: charset{Name: "utf8"}, Confidence: 1}, nil
+}
+
+// undoUtf8AsCp1251 restores utf8 text misread as cp1251
+func undoUtf8AsCp1251(s string, _ *charset) (string, charsetGuess, error) {
+	raw, err := charmap.Windows1251.NewEncoder().String(s)
+	if err != nil {
+		return "", charsetGuess{}, err
+	}
+	if !utf8.ValidString(raw) {
+		return "", charsetGuess{}, errors.New("not an utf8 misread as cp1251")
+	}
+	return raw, charsetGuess{Charset: charset{Name: "utf8"}, Confidence: 1}, nil
+}
+
+// brokenToUtf8 restores broken text trying every mangling chain and choosing the most plausible cyrillic.
+// Text is returned as is, unless a chain makes it more plausible
+func brokenToUtf8(s string, dec decoding) (string, charsetGuess, error) {
+	class := classifyText(s)
+	if class == textUndecodable {
+		return "", charsetGuess{}, fmt.Errorf("%w: %q", errUndecodable, s)
+	}
+	chains := dec.chains
+	if len(chains) == 0 {
+		chains = manglingChains[:1]
+	}
+
+	// a candidate must be more plausible than the text itself, so that e.g. accented latin is left alone
+	res, guess, bestScore := s, charsetGuess{}, cyrillicScore(s)
+	fits := false
+	var lastErr error
+	for _, chain := range chains {
+		candidate, candidateGuess, err := chain.undo(s, dec.charset)
+		if err == nil && classifyText(candidate) == textUndecodable {
+			err = fmt.Errorf("%w: %q", errUndecodable, candidate)
+		}
+		if err != nil {
+			log.Trace().Err(err).Msgf("Mangling chain %s does not fit", chain.Name)
+			lastErr = err
+			continue
+		}
+		fits = true
+		score := cyrillicScore(candidate)
+		log.Trace().Msgf("Mangling chain %s scored %.2f: %s", chain.Name, score, candidate)
+		// a chain leaving the text as is still tells its charset
+		if score > bestScore || candidate == res && guess.Charset.Name == "" {
+			res, guess, bestScore = candidate, candidateGuess, score
+		}
+	}
+	if class != textUnicode && !fits {
+		return "", charsetGuess{}, fmt.Errorf("no mangling chain fits: %w", lastErr)
+	}
+	if res == s {
+		log.Trace().Msgf("Text is more plausible than any fix, skipping: %s", s)
+	}
+
+	return res, guess, nil
+}
+
+// mojibakeBytes restores original bytes of a string, which were erroneously decoded as latin1 or cp1252
+func mojibakeBytes(s string) ([]byte, error) {
+	encoder := charmap.Windows1252.NewEncoder()
+	res := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r <= 0xff {
+			// latin1 maps bytes to runes one-to-one
+			res = append(res, byte(r))
+			continue
+		}
+		b, err := encoder.Bytes([]byte(string(r)))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, b...)
+	}
+	log.Trace().Msg("utf8->1byte:\n" + formatBytes(string(res)))
+
+	return res, nil
+}
+
+// normalizeURL re-encodes non-ascii parts of a URL, percent-encoded, mangled into latin1 or written as is, as
+// percent-encoded utf8, which is the URI form of an IRI (RFC 3987). Each run of non-ascii bytes is kept as utf8
+// if it is valid, otherwise it is decoded from a single-byte code page, which is detected when cs is nil
+func normalizeURL(u string, cs *charset) (string, charsetGuess, error) {
+	// ascii parts of the URL and runs of non-ascii bytes between them
+	type part struct {
+		text    string
+		raw     []byte
+		escaped bool // percent-encoded bytes, which do not make a run with unescaped ones
+	}
+	class := classifyText(u)
+	if class == textUndecodable {
+		return "", charsetGuess{}, fmt.Errorf("%w: %q", errUndecodable, u)
+	}
+
+	parts := []part{}
+	changed := false
+	addByte := func(b byte, escaped bool) {
+		if len(parts) == 0 || parts[len(parts)-1].raw == nil || parts[len(parts)-1].escaped != escaped {
+			parts = append(parts, part{raw: []byte{}, escaped: escaped})
+		}
+		parts[len(parts)-1].raw = append(parts[len(parts)-1].raw, b)
+	}
+	for i := 0; i < len(u); {
+		if u[i] == '%' && i+2 < len(u) && isHex(u[i+1]) && isHex(u[i+2]) {
+			b := unhex(u[i+1])<<4 | unhex(u[i+2])
+			if b >= 0x80 {
+				addByte(b, true)
+				i += 3
+				continue
+			}
+			parts = append(parts, part{text: u[i : i+3]})
+			i += 3
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(u[i:])
+		if r < 0x80 {
+			parts = append(parts, part{text: u[i : i+size]})
+			i += size
+			continue
+		}
+		changed = true
+		raw := []byte(u[i : i+size])
+		if class != textUnicode {
+			var err error
+			if raw, err = mojibakeBytes(string(r)); err != nil {
+				return "", charsetGuess{}, err
+			}
+		}
+		for _, b := range raw {
+			addByte(b, false)
+		}
+		i += size
+	}
+
+	// only runs which are not valid utf8 are subject to charset detection
+	broken := []byte{}
+	for _, p := range parts {
+		if p.raw != nil && !utf8.Valid(p.raw) {
+			broken = append(broken, p.raw...)
+		}
+	}
+	if len(broken) == 0 && !changed {
+		return u, charsetGuess{}, nil
+	}
+	guess := charsetGuess{Charset: charset{Name: "utf8"}, Confidence: 1}
+	if len(broken) > 0 {
+		if cs != nil {
+			guess = charsetGuess{Charset: *cs, Confidence: 1}
+		} else {
+			guess = detectCharset(broken)
+			log.Trace().Msgf("Detected charset %s (confidence %.2f)", guess.Charset.Name, guess.Confidence)
+		}
+	}
+	res := strings.Builder{}
+	for _, p := range parts {
+		if p.raw == nil {
+			res.WriteString(p.text)
+			continue
+		}
+		text := p.raw
+		if !utf8.Valid(p.raw) && guess.Charset.Encoding != nil {
+			var err error
+			text, err = guess.Charset.Encoding.NewDecoder().Bytes(p.raw)
+			if err != nil {
+				return "", charsetGuess{}, err
+			}
+		}
+		for _, b := range text {
+			fmt.Fprintf(&res, "%%%02X", b)
+		}
+	}
+	return res.String(), guess, nil
+}
+
+func isASCIILetter(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z'
+}
+
+func hasNonASCII(raw []byte) bool {
+	for _, b := range raw {
+		if b >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return false
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
+
+// detectCharset scores every candidate code page by the letter and bigram statistics of the decoded text
+// and returns the most plausible one. If none is plausible enough, cp1252 is returned with zero confidence
+func detectCharset(raw []byte) charsetGuess {
+	candidates := make([]charset, 0, len(detectableCharsets))
+	for _, name := range detectableCharsets {
+		cs, err := lookupCharset(name)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Unknown detectable charset. This is probably a bug!")
+		}
+		candidates = append(candidates, cs)
+	}
+
+	scores := make([]float64, len(candidates))
+	for i, cs := range candidates {
+		decoded, err := cs.Encoding.NewDecoder().Bytes(raw)
+		if err != nil {
+			scores[i] = math.Inf(-1)
+			continue
+		}
+		scores[i] = cyrillicScore(string(decoded))
+		log.Trace().Msgf("Charset %s scored %.2f", cs.Name, scores[i])
+	}
+
+	best := 0
+	for i := range scores {
+		if scores[i] > scores[best] {
+			best = i
+		}
+	}
+	// the first candidate is cp1251
+	if best != 0 && scores[0] > 0 && scores[best]-scores[0] < detectMargin {
+		log.Trace().Msgf("Charset %s does not outscore %s by %.2f, keeping the latter",
+			candidates[best].Name, candidates[0].Name, detectMargin)
+		best = 0
+	}
+	if scores[best] < minDetectScore && !(best == 0 && scores[0] > 0) && hasNonASCII(raw) {
+		log.Trace().Msgf("No plausible cyrillic code page (best %s scored %.2f), falling back to %s",
+			candidates[best].Name, scores[best], fallbackCharset.Name)
+		return charsetGuess{Charset: fallbackCharset}
+	}
+	// softmax over the scores gives a confidence in 0..1
+	sum := 0.0
+	for i := range scores {
+		sum += math.Exp(scores[i] - scores[best])
+	}
+
+	return charsetGuess{Charset: candidates[best], Confidence: 1 / sum}
+}
+
+// typographic symbols of cp1252 and cp1251, which are as common in western texts as in russian ones
+const typographicSymbols = "©®™°±№€"
+
+// cyrillicScore estimates how plausible a string is as a russian text, averaged per non-ascii rune.
+// Punctuation is neutral, as curly quotes and dashes are found in texts of any language
+func cyrillicScore(s string) float64 {
+	score := 0.0
+	nonASCII := 0
+	var prev rune
+	for _, r := range s {
+		switch {
+		case r < utf8.RuneSelf:
+			if isASCIILetter(r) && unicode.Is(unicode.Cyrillic, prev) {
+				score -= 10
+			}
+		case unicode.IsPunct(r) || strings.ContainsRune(typographicSymbols, r):
+		default:
+			nonASCII += 1
+			lower := unicode.ToLower(r)
+			if freq, ok := cyrillicLetterFreq[lower]; ok {
+				score += freq / 2
+			} else if strings.ContainsRune(slavicLetters, lower) {
+				// valid, but unusual in russian texts
+				score += 0.1
+			} else if unicode.Is(unicode.Cyrillic, r) {
+				// serbian, macedonian and archaic letters, mostly produced by a wrong code page
+				score -= 1
+			} else if !unicode.Is(unicode.Latin, r) {
+				// box drawing, control characters and similar garbage
+				score -= 5
+			}
+			if unicode.IsUpper(r) && unicode.IsLower(prev) {
+				// case switch in the middle of a word is a strong sign of a wrong code page, it outweighs
+				// the most frequent letter along with a bigram
+				score -= 8
+			}
+			if unicode.Is(unicode.Cyrillic, r) && isASCIILetter(prev) {
+				// so is a mixture of latin and cyrillic letters in a word, it outweighs any cyrillic letter
+				score -= 10
+			}
+			if cyrillicBigrams[string([]rune{unicode.ToLower(prev), lower})] {
+				score += 2
+			}
+		}
+		prev = r
+	}
+	if nonASCII == 0 {
+		return 0
+	}
+
+	return score / float64(nonASCII)
+}
+
+// brokenCp1251ToUtf8 re-encodes 1-byte string in a given code page (usually cp1251), erroneously encoded
+// as a 2-byte string, back to a valid utf8
+func brokenCp1251ToUtf8(s string, cs charset) (string, error) {
 	log.Trace().Msg("Fix bytes (bin):\n" + formatBytes(s))
 	log.Trace().Msg("Fix bytes (dec):\n" + formatBytes10(s))
 
 	// this removes invalid utf8 "first" bytes from byte pairs, leaving only "second" bytes with correct padding
-	// Windows1252 is an arbitrary 1 byte encoder, as it supports more runes than other 125X
-	// output string is a correct 1 byte cp1251 string
-	encoder := charmap.Windows1252.NewEncoder()
-	res, err := encoder.String(s)
+	// output string is a correct 1 byte string
+	raw, err := mojibakeBytes(s)
 	if err != nil {
 		return "", err
 	}
-	log.Trace().Msg("utf8->1byte:\n" + formatBytes(res))
 
 	// now encode it into utf8
-	decoder := charmap.Windows1251.NewDecoder()
-	res, err = decoder.String(res)
+	res, err := cs.Encoding.NewDecoder().Bytes(raw)
 	if err != nil {
 		return "", err
 	}
-	log.Trace().Msg("1byte->utf8:\n" + formatBytes(res))
+	log.Trace().Msg("1byte->utf8:\n" + formatBytes(string(res)))
 
-	return res, nil
+	return string(res), nil
 }
 
-// cp1251ToTranslit transliterates a valid 1-byte cp1251 string to a valid latin1
-func cp1251ToTranslit(s string, maxByteLength int) (string, error) {
+// decode1byte decodes a valid 1-byte string in a given code page, the code page is detected when cs is nil.
+// Some taggers write utf8 into id3v1 tags, such strings are returned as is
+func decode1byte(s string, cs *charset) (string, error) {
 	log.Trace().Msg("Fix bytes (bin):\n" + formatBytes(s))
 	log.Trace().Msg("Fix bytes (dec):\n" + formatBytes10(s))
 
-	decoder := charmap.Windows1251.NewDecoder()
-	res, err := decoder.String(s)
+	if classifyText(s) == textUnicode {
+		log.Trace().Msg("Text is valid unicode, skipping decoding")
+		return s, nil
+	}
+	if cs == nil {
+		guess := detectCharset([]byte(s))
+		log.Trace().Msgf("Detected charset %s (confidence %.2f)", guess.Charset.Name, guess.Confidence)
+		cs = &guess.Charset
+	}
+	res, err := cs.Encoding.NewDecoder().String(s)
 	if err != nil {
 		return "", err
 	}
-	log.Trace().Msg("1byte->utf8:\n" + formatBytes(s))
+	log.Trace().Msg("1byte->utf8:\n" + formatBytes(res))
+
+	return res, nil
+}
 
-	transliterator := translit.ICAO
-	translit := transliterator(res)
+// utf8ToCp1251 encodes a correct utf8 string into cp1251, runes missing there are transliterated
+func utf8ToCp1251(s string, tr transliterator) string {
+	res := []byte{}
+	for _, r := range s {
+		if b, ok := charmap.Windows1251.EncodeRune(r); ok {
+			res = append(res, b)
+		} else {
+			res = append(res, tr.Translit(string(r))...)
+		}
+	}
+	return string(res)
+}
+
+// utf8ToTranslit transliterates a correct utf8 string into ascii
+func utf8ToTranslit(s string, tr transliterator) string {
+	translit := tr.Translit(s)
 	log.Trace().Msg("utf8->translit:\n" + translit)
 
-	return truncateUtf8(translit, maxByteLength), nil
+	return translit
 }
 
-// truncateUtf8 truncates a string to a maximum byte length, ensuring that it does not cut off
-// a UTF-8 character in the middle
-func truncateUtf8(s string, maxByteLength int) string {
-	limit := maxByteLength
-	if len(s) <= limit {
+// truncateWords truncates a 1-byte string (ascii or cp1251) to a maximum byte length on a word boundary, unless
+// this drops more than a half of the string, and ends it with an ellipsis if one is given
+func truncateWords(s string, maxByteLength int, ellipsis string) string {
+	if len(s) <= maxByteLength {
 		return s
 	}
-	for limit > 0 && !utf8.RuneStart(s[limit]) {
-		limit -= 1
+	limit := maxByteLength - len(ellipsis)
+	if limit < 1 {
+		limit, ellipsis = maxByteLength, ""
+	}
+	res := s[:limit]
+	if s[limit] != ' ' {
+		if i := strings.LastIndexByte(res, ' '); i > limit/2 {
+			res = res[:i]
+		}
 	}
-	s = s[:limit]
-	log.Trace().Msgf("truncated string to %d (<=%d) bytes: %s", limit, maxByteLength, s)
+	res = strings.TrimRight(res, " ,;:")
+	log.Trace().Msgf("truncated string to %d (<=%d) bytes: %s", len(res), maxByteLength, res)
 
-	return s
+	return res + ellipsis
 }
 
 func formatBytes(s string) string {
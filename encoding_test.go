@@ -4,42 +4,259 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/charmap"
 )
 
 func TestBrokenCp1251ToUtf8(t *testing.T) {
-	actual, err := brokenCp1251ToUtf8("ÐÀÎ Ãîâîðÿùàÿ êíèãà")
+	cp1251, err := lookupCharset("cp1251")
+	assert.NoError(t, err)
+
+	actual, err := brokenCp1251ToUtf8("ÐÀÎ Ãîâîðÿùàÿ êíèãà", cp1251)
 	assert.NoError(t, err)
 	assert.Equal(t, actual, "РАО Говорящая книга")
 
-	actual, err = brokenCp1251ToUtf8(`"Âîêðóã ñâåòà"`)
+	actual, err = brokenCp1251ToUtf8(`"Âîêðóã ñâåòà"`, cp1251)
 	assert.NoError(t, err)
 	assert.Equal(t, actual, `"Вокруг света"`, "should fix the mixture of ascii and cp1251")
 
-	_, err = brokenCp1251ToUtf8("А. и Б. Стругацкие")
+	_, err = brokenCp1251ToUtf8("А. и Б. Стругацкие", cp1251)
 	assert.ErrorContains(t, err, "rune not supported", "should fail on utf8")
 
-	actual, err = brokenCp1251ToUtf8("2005")
+	actual, err = brokenCp1251ToUtf8("2005", cp1251)
 	assert.NoError(t, err)
 	assert.Equal(t, actual, "2005", "should not change ascii")
+
+	koi8u, err := lookupCharset("koi8-u")
+	assert.NoError(t, err)
+	raw, err := koi8u.Encoding.NewEncoder().String("Їжачок")
+	assert.NoError(t, err)
+	actual, err = brokenCp1251ToUtf8(latin1(raw), koi8u)
+	assert.NoError(t, err)
+	assert.Equal(t, "Їжачок", actual, "should use a given code page")
 }
 
-func TestCp1251ToTranslit(t *testing.T) {
-	actual, err := cp1251ToTranslit(string([]byte{192, 46, 32, 232, 32, 32, 193, 46, 32, 209, 242, 240, 243, 227, 224, 246, 234, 232, 229}), 80)
+func TestLookupCharset(t *testing.T) {
+	cs, err := lookupCharset("koi8-r")
+	assert.NoError(t, err)
+	assert.Equal(t, "koi8-r", cs.Name)
+
+	cs, err = lookupCharset("Windows-1253")
+	assert.NoError(t, err)
+	assert.Equal(t, "cp1253", cs.Name, "should accept windows-125X aliases")
+
+	_, err = lookupCharset("ebcdic")
+	assert.EqualError(t, err, "charset ebcdic not supported")
+}
+
+func TestUtf8ToTranslit(t *testing.T) {
+	assert.Equal(t, "A. i  B. Strugatskie", utf8ToTranslit("А. и  Б. Стругацкие", transliterator{}), "should transliterate")
+	assert.Equal(t, "06:55, 44 100 Hz, Stereo, 19", utf8ToTranslit("06:55, 44 100 Hz, Stereo, 19", transliterator{}),
+		"should not change ascii")
+}
+
+func TestDecode1byte(t *testing.T) {
+	koi8r, err := charmap.KOI8R.NewEncoder().String("Сказка о Тройке")
+	assert.NoError(t, err)
+	cp1251, err := charmap.Windows1251.NewEncoder().String("Сказка о Тройке")
+	assert.NoError(t, err)
+
+	actual, err := decode1byte(koi8r, nil)
 	assert.NoError(t, err)
-	assert.Equal(t, "A. i  B. Strugatskie", actual, "should transliterate")
+	assert.Equal(t, "Сказка о Тройке", actual, "should detect koi8-r")
 
-	actual, err = cp1251ToTranslit("06:55, 44 100 Hz, Stereo, 19", 80)
+	actual, err = decode1byte(cp1251, nil)
 	assert.NoError(t, err)
-	assert.Equal(t, "06:55, 44 100 Hz, Stereo, 19", actual, "should not change ascii")
+	assert.Equal(t, "Сказка о Тройке", actual, "should detect cp1251")
+
+	actual, err = decode1byte("Сказка о Тройке", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Сказка о Тройке", actual, "should keep utf8")
 }
 
-func TestTruncateUtf8(t *testing.T) {
-	actual := truncateUtf8("А. и Б. Стругацкие", 80)
-	assert.Equal(t, "А. и Б. Стругацкие", actual, "should not truncate")
+func TestUtf8ToCp1251(t *testing.T) {
+	assert.Equal(t, "\xd2\xe0\xf0\xe0\xf1 Shevchenko", utf8ToCp1251("Тарас Shevchenko", transliterator{}))
+	assert.Equal(t, "Ivan \xb9 O", utf8ToCp1251("Ivan № Ő", transliterator{}), "should transliterate missing runes")
+}
+
+func TestTruncateWords(t *testing.T) {
+	for _, tc := range []struct {
+		s        string
+		max      int
+		ellipsis string
+		expected string
+	}{
+		{"Skazka o Trojke", 30, "...", "Skazka o Trojke"},
+		{"Ponedelnik nachinaetsya v subbotu", 30, "", "Ponedelnik nachinaetsya v"},
+		{"Ponedelnik nachinaetsya v subbotu", 30, "...", "Ponedelnik nachinaetsya v..."},
+		{"Ponedelnik nachinaetsya, v subbotu", 25, "", "Ponedelnik nachinaetsya"},
+		{"Ponedelnik nachinaetsya v subbotu", 25, "", "Ponedelnik nachinaetsya v"},
+		{"Ponedelniknachinaetsya v subbotu", 20, "...", "Ponedelniknachina..."},
+		{"\xcf\xee\xed\xe5\xe4\xe5\xeb\xfc\xed\xe8\xea \xed\xe0\xf7\xe8\xed\xe0", 15, "\x85",
+			"\xcf\xee\xed\xe5\xe4\xe5\xeb\xfc\xed\xe8\xea\x85"},
+	} {
+		assert.Equal(t, tc.expected, truncateWords(tc.s, tc.max, tc.ellipsis), tc.s)
+	}
+}
 
-	actual = truncateUtf8("А. и Б. Стругацкие", 29)
-	assert.Equal(t, "А. и Б. Стругацки", actual, "should truncate")
+func TestBrokenToUtf8(t *testing.T) {
+	actual, guess, err := brokenToUtf8("ÐÀÎ Ãîâîðÿùàÿ êíèãà", decoding{})
+	assert.NoError(t, err)
+	assert.Equal(t, "РАО Говорящая книга", actual)
+	assert.Equal(t, "cp1251", guess.Charset.Name)
+	assert.Greater(t, guess.Confidence, 0.5)
+
+	koi8r, err := charmap.KOI8R.NewEncoder().String("Понедельник начинается в субботу")
+	assert.NoError(t, err)
+	actual, guess, err = brokenToUtf8(latin1(koi8r), decoding{})
+	assert.NoError(t, err)
+	assert.Equal(t, "Понедельник начинается в субботу", actual)
+	assert.Equal(t, "koi8-r", guess.Charset.Name, "should detect koi8-r")
+
+	cp866, err := charmap.CodePage866.NewEncoder().String("Сказка о Тройке")
+	assert.NoError(t, err)
+	actual, guess, err = brokenToUtf8(latin1(cp866), decoding{})
+	assert.NoError(t, err)
+	assert.Equal(t, "Сказка о Тройке", actual)
+	assert.Equal(t, "cp866", guess.Charset.Name, "should detect cp866")
+
+	iso, err := charmap.ISO8859_5.NewEncoder().String("Стругацкие")
+	assert.NoError(t, err)
+	actual, guess, err = brokenToUtf8(latin1(iso), decoding{})
+	assert.NoError(t, err)
+	assert.Equal(t, "Стругацкие", actual)
+	assert.Equal(t, "iso-8859-5", guess.Charset.Name, "should detect iso-8859-5")
+
+	actual, guess, err = brokenToUtf8("2005", decoding{})
+	assert.NoError(t, err)
+	assert.Equal(t, "2005", actual, "should not change ascii")
+	assert.Equal(t, "cp1251", guess.Charset.Name, "should fall back to cp1251 on ascii")
+
+	actual, _, err = brokenToUtf8("А. и Б. Стругацкие", decoding{})
+	assert.NoError(t, err)
+	assert.Equal(t, "А. и Б. Стругацкие", actual, "should not change valid utf8")
+
+	_, _, err = brokenToUtf8("\xc0. \xe8 \xc1.", decoding{})
+	assert.ErrorIs(t, err, errUndecodable, "should fail on invalid utf8")
+	_, _, err = brokenToUtf8("Стругацкие \uFFFD", decoding{})
+	assert.ErrorIs(t, err, errUndecodable, "should fail on replacement characters")
+}
+
+func TestBrokenToUtf8_ShortNames(t *testing.T) {
+	// short names look a bit more plausible in other code pages, cp1251 must be kept for them
+	for _, text := range []string{"Цой", "Чайф", "Ю. Шевчук", "Я", "Эх", "ДДТ", "Би-2"} {
+		cp1251, err := charmap.Windows1251.NewEncoder().String(text)
+		assert.NoError(t, err)
+
+		actual, guess, err := brokenToUtf8(latin1(cp1251), decoding{})
+		assert.NoError(t, err)
+		assert.Equal(t, text, actual)
+		assert.Equal(t, "cp1251", guess.Charset.Name, text)
+
+		actual, err = decode1byte(cp1251, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, text, actual, "should keep cp1251 in id3v1")
+	}
+}
+
+func TestBrokenToUtf8_Western(t *testing.T) {
+	for _, text := range []string{"Rock’n’Roll", "“Hotel California” – Live", "Beyoncé", "Sigur Rós", "Björk…"} {
+		actual, _, err := brokenToUtf8(text, decoding{})
+		assert.NoError(t, err)
+		assert.Equal(t, text, actual, "should not change cp1252 text")
+	}
+
+	guess := detectCharset([]byte("Beyonc\xe9"))
+	assert.Equal(t, "cp1252", guess.Charset.Name, "should fall back to cp1252")
+	assert.Zero(t, guess.Confidence)
+
+	all := decoding{chains: manglingChains}
+	for _, text := range []string{"Café del Mar", "Motörhead", "Mötley Crüe", "Ærøskøbing", "Édith Piaf"} {
+		actual, _, err := brokenToUtf8(text, all)
+		assert.NoError(t, err)
+		assert.Equal(t, text, actual, "should not change accented latin with any chain")
+	}
+
+	actual, _, err := brokenToUtf8("«Âîêðóã ñâåòà»", decoding{})
+	assert.NoError(t, err)
+	assert.Equal(t, "«Вокруг света»", actual, "should fix cyrillic in curly quotes")
+}
+
+func TestBrokenToUtf8_Chains(t *testing.T) {
+	// double cp1251 mangling of м and ё can not be reversed, as it goes through 0x98 undefined in cp1251
+	text := "Понедельник начинается в субботу"
+	cp1251, err := charmap.Windows1251.NewEncoder().String(text)
+	assert.NoError(t, err)
+	utf8AsCp1251, err := charmap.Windows1251.NewDecoder().String(text)
+	assert.NoError(t, err)
+	doubleUtf8AsCp1251, err := charmap.Windows1251.NewDecoder().String(utf8AsCp1251)
+	assert.NoError(t, err)
+	all := decoding{chains: manglingChains}
+
+	for _, tc := range []struct {
+		name    string
+		broken  string
+		charset string
+	}{
+		{"1byte-latin1", latin1(cp1251), "cp1251"},
+		{"utf8-latin1", latin1(text), "utf8"},
+		{"utf8-cp1251", utf8AsCp1251, "utf8"},
+		{"1byte-latin1-utf8-latin1", latin1(latin1(cp1251)), "cp1251"},
+		{"utf8-latin1-utf8-latin1", latin1(latin1(text)), "utf8"},
+		{"utf8-cp1251-utf8-cp1251", doubleUtf8AsCp1251, "utf8"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, guess, err := brokenToUtf8(tc.broken, all)
+			assert.NoError(t, err)
+			assert.Equal(t, text, actual)
+			assert.Equal(t, tc.charset, guess.Charset.Name)
+		})
+	}
+
+	actual, _, err := brokenToUtf8(text, all)
+	assert.NoError(t, err)
+	assert.Equal(t, text, actual, "should not change valid utf8")
+
+	actual, _, err = brokenToUtf8(utf8AsCp1251, decoding{})
+	assert.NoError(t, err)
+	assert.Equal(t, utf8AsCp1251, actual, "should try only 1byte-latin1 by default")
+
+	utf8AsCp1251Chain, err := lookupManglingChain("utf8-cp1251")
+	assert.NoError(t, err)
+	_, _, err = brokenToUtf8(latin1(cp1251), decoding{chains: []manglingChain{utf8AsCp1251Chain}})
+	assert.Error(t, err, "should fail if no chain fits mojibake")
+}
+
+func TestNormalizeURL(t *testing.T) {
+	actual, _, err := normalizeURL("http://a.ru/Сайт?x=%D1%E0", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://a.ru/%D0%A1%D0%B0%D0%B9%D1%82?x=%D0%A1%D0%B0", actual, "should re-encode cp1251 escapes of an IRI")
+
+	actual, _, err = normalizeURL("http://a.ru/%D0%A1%D0%B0", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://a.ru/%D0%A1%D0%B0", actual, "should keep percent-encoded utf8")
+}
+
+func TestClassifyText(t *testing.T) {
+	for s, class := range map[string]textClass{
+		"":                    textASCII,
+		"2005":                textASCII,
+		"ÐÀÎ Ãîâîðÿùàÿ êíèãà": textMojibake,
+		latin1("\x80\x98"):    textMojibake,
+		"Àâòîð – „Ñòðóãàöêèå“": textMojibake,
+		"А. и Б. Стругацкие":   textUnicode,
+		"Ãîâîðÿùàÿ книга":      textUnicode,
+		"\xc0\xe8":             textUndecodable,
+		"книга \uFFFD":         textUndecodable,
+	} {
+		assert.Equal(t, class, classifyText(s), s)
+	}
+}
 
-	actual = truncateUtf8("А. и Б. Стругацкие", 30)
-	assert.Equal(t, "А. и Б. Стругацки", actual, "should truncate with respect to utf8")
+// latin1 mimics a tagger, which decoded 1-byte string as latin1
+func latin1(s string) string {
+	res := make([]rune, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		res = append(res, rune(s[i]))
+	}
+	return string(res)
 }
@@ -1,24 +1,49 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 )
 
 // see https://stackoverflow.com/questions/21060945/simple-way-to-copy-a-file
-// safely copy file with checks that destination does not exists
-func copyFileSafe(src, dst string) (err error) {
+// safely copy file with checks that destination does not exists, carrying over file attributes
+func copyFileSafe(src, dst string) error {
+	return installFile(src, dst, src, true)
+}
+
+// installFile safely copies src to a new file dst, giving it attributes of attrsFrom, e.g. the original of
+// a fixed temp file. Only permission bits are carried over unless preserve is set
+func installFile(src, dst, attrsFrom string, preserve bool) (err error) {
 	dstExists, _ := fileExists(dst)
 	if dstExists {
 		return fmt.Errorf("destination file %s already exists", dst)
 	}
 
 	err = copyFileContents(src, dst)
+	if err != nil {
+		return
+	}
+	err = applyFileAttrs(attrsFrom, dst, preserve)
 	return
 }
 
+// applyFileAttrs carries all the attributes of src over to dst if preserve is set, only permission bits otherwise
+func applyFileAttrs(src, dst string, preserve bool) error {
+	if preserve {
+		return copyFileAttrs(src, dst)
+	}
+	stat, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(dst, stat.Mode().Perm())
+}
+
 func copyFileContents(src, dst string) (err error) {
 	// check source file
 	srcStat, err := os.Stat(src)
@@ -55,6 +80,45 @@ func copyFileContents(src, dst string) (err error) {
 	return
 }
 
+// sameFileContents compares sizes and sha256 hashes of two files
+func sameFileContents(a, b string) (bool, error) {
+	aStat, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	bStat, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	if aStat.Size() != bStat.Size() {
+		return false, nil
+	}
+
+	aHash, err := fileHash(a)
+	if err != nil {
+		return false, err
+	}
+	bHash, err := fileHash(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(aHash, bHash), nil
+}
+
+func fileHash(name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
 func fileExists(name string) (bool, error) {
 	_, err := os.Stat(name)
 	if err == nil {
@@ -71,3 +135,65 @@ func fileExists(name string) (bool, error) {
 		}
 	}
 }
+
+// replaceFile atomically replaces dst with a copy of src: the copy is written to a sibling temp file,
+// synced, given attributes of attrsFrom and renamed over dst, so dst is always either fully old or fully new.
+// Only permission bits of attrsFrom are applied unless preserve is set. A missing attrsFrom is replaced with src
+func replaceFile(src, dst, attrsFrom string, preserve bool) (err error) {
+	if _, err = os.Stat(attrsFrom); errors.Is(err, os.ErrNotExist) {
+		attrsFrom = src
+	} else if err != nil {
+		return
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return
+	}
+	tmpName := tmpFile.Name()
+	tmpFile.Close()
+	defer func() {
+		if err != nil {
+			os.Remove(tmpName)
+		}
+	}()
+
+	// copyFileContents syncs the copy
+	if err = copyFileContents(src, tmpName); err != nil {
+		return
+	}
+	if err = applyFileAttrs(attrsFrom, tmpName, preserve); err != nil {
+		return
+	}
+	if err = os.Rename(tmpName, dst); err != nil {
+		return
+	}
+
+	// make the rename itself durable
+	err = syncDir(filepath.Dir(dst))
+	return
+}
+
+// copyFileAttrs carries permission bits, ownership, extended attributes and access and modification times
+// of src over to dst. Ownership and extended attributes are copied where possible
+func copyFileAttrs(src, dst string) error {
+	stat, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(dst, stat.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := copyFileOwner(stat, dst); err != nil {
+		// only root may give files away, so it is not an error
+		if !errors.Is(err, os.ErrPermission) {
+			return err
+		}
+	}
+	if err := copyFileXattrs(src, dst); err != nil {
+		return err
+	}
+	// zero access time is left unchanged
+	atime, _ := fileAtime(stat)
+	return os.Chtimes(dst, atime, stat.ModTime())
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// fileAtime returns the last access time of a file
+func fileAtime(stat os.FileInfo) (time.Time, bool) {
+	sys, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(sys.Atim.Unix()), true
+}
+
+// copyFileXattrs copies user extended attributes, doing nothing if the file system does not support them
+func copyFileXattrs(src, dst string) error {
+	size, err := syscall.Listxattr(src, nil)
+	if err != nil || size == 0 {
+		if err != nil && !errors.Is(err, syscall.ENOTSUP) {
+			return err
+		}
+		return nil
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(src, buf)
+	if err != nil {
+		return err
+	}
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		// system and security attributes are managed by the kernel or need privileges
+		if !strings.HasPrefix(name, "user.") {
+			continue
+		}
+		valueSize, err := syscall.Getxattr(src, name, nil)
+		if err != nil {
+			return err
+		}
+		value := make([]byte, valueSize)
+		valueSize, err = syscall.Getxattr(src, name, value)
+		if err != nil {
+			return err
+		}
+		if err := syscall.Setxattr(dst, name, value[:valueSize], 0); err != nil && !errors.Is(err, syscall.ENOTSUP) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopyFileXattrs(t *testing.T) {
+	dir := t.TempDir()
+	srcFileName := path.Join(dir, "srcFile.txt")
+	dstFileName := path.Join(dir, "dstFile.txt")
+	assert.NoError(t, os.WriteFile(srcFileName, []byte("Hello, World!"), 0644))
+	assert.NoError(t, os.WriteFile(dstFileName, []byte("Hello, World!"), 0644))
+	if err := syscall.Setxattr(srcFileName, "user.comment", []byte("audiobook"), 0); err != nil {
+		t.Skipf("extended attributes not supported: %s", err)
+	}
+
+	err := copyFileXattrs(srcFileName, dstFileName)
+	assert.NoError(t, err)
+
+	value := make([]byte, 64)
+	size, err := syscall.Getxattr(dstFileName, "user.comment", value)
+	assert.NoError(t, err)
+	assert.Equal(t, "audiobook", string(value[:size]))
+}
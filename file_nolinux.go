@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// fileAtime returns false, as the last access time is not portable
+func fileAtime(stat os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// copyFileXattrs does nothing, extended attributes are only copied on linux
+func copyFileXattrs(src, dst string) error {
+	return nil
+}
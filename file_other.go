@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+)
+
+// copyFileOwner does nothing, as there are no unix owners here
+func copyFileOwner(stat os.FileInfo, name string) error {
+	return nil
+}
+
+// syncDir does nothing, as directories can not be synced here
+func syncDir(dir string) error {
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -32,3 +33,62 @@ func TestCopyFileSafe(t *testing.T) {
     assert.Error(t, err)
     assert.Equal(t, "destination file "+dstFileName+" already exists", err.Error())
 }
+
+func TestReplaceFile(t *testing.T) {
+	dir := t.TempDir()
+	srcFileName := path.Join(dir, "srcFile.txt")
+	dstFileName := path.Join(dir, "dstFile.txt")
+	assert.NoError(t, os.WriteFile(srcFileName, []byte("new"), 0644))
+	assert.NoError(t, os.WriteFile(dstFileName, []byte("old contents"), 0600))
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.NoError(t, os.Chtimes(dstFileName, mtime, mtime))
+
+	err := replaceFile(srcFileName, dstFileName, dstFileName, true)
+	assert.NoError(t, err)
+
+	dstContents, err := os.ReadFile(dstFileName)
+	assert.NoError(t, err)
+	assert.Equal(t, "new", string(dstContents))
+	stat, err := os.Stat(dstFileName)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), stat.Mode().Perm(), "should keep mode")
+	assert.True(t, mtime.Equal(stat.ModTime()), "should keep modification time")
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2, "should not leave temp files")
+}
+
+func TestSameFileContents(t *testing.T) {
+	dir := t.TempDir()
+	a := path.Join(dir, "a.txt")
+	b := path.Join(dir, "b.txt")
+	assert.NoError(t, os.WriteFile(a, []byte("Hello, World!"), 0644))
+	assert.NoError(t, os.WriteFile(b, []byte("Hello, World!"), 0600))
+
+	same, err := sameFileContents(a, b)
+	assert.NoError(t, err)
+	assert.True(t, same)
+
+	assert.NoError(t, os.WriteFile(b, []byte("Hello, world!"), 0600))
+	same, err = sameFileContents(a, b)
+	assert.NoError(t, err)
+	assert.False(t, same, "should compare contents, not only sizes")
+}
+
+func TestCopyFileSafe_Attrs(t *testing.T) {
+	dir := t.TempDir()
+	srcFileName := path.Join(dir, "srcFile.txt")
+	dstFileName := path.Join(dir, "dstFile.txt")
+	assert.NoError(t, os.WriteFile(srcFileName, []byte("Hello, World!"), 0640))
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.NoError(t, os.Chtimes(srcFileName, mtime, mtime))
+
+	err := copyFileSafe(srcFileName, dstFileName)
+	assert.NoError(t, err)
+
+	stat, err := os.Stat(dstFileName)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), stat.Mode().Perm(), "should keep mode")
+	assert.True(t, mtime.Equal(stat.ModTime()), "should keep modification time")
+}
@@ -0,0 +1,27 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// copyFileOwner sets ownership of a file to the one described by stat
+func copyFileOwner(stat os.FileInfo, name string) error {
+	sys, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return os.Chown(name, int(sys.Uid), int(sys.Gid))
+}
+
+// syncDir flushes directory entries, e.g. after a rename
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/bogem/id3v2/v2"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// frames the library does not parse or are not listed in its common ids. Ids are the same in id3v2.3 and id3v2.4
+var extraFrameIDs = map[string]string{
+	"Unsynchronised lyrics/text transcription": "USLT",
+	"Synchronised lyrics/text":                 "SYLT",
+	"General encapsulated object":              "GEOB",
+	"Table of contents":                        "CTOC",
+
+	"Commercial information":                   "WCOM",
+	"Copyright/Legal information":              "WCOP",
+	"Official audio file webpage":              "WOAF",
+	"Official artist/performer webpage":        "WOAR",
+	"Official audio source webpage":            "WOAS",
+	"Official internet radio station homepage": "WORS",
+	"Payment":                     "WPAY",
+	"Publishers official webpage": "WPUB",
+	"User defined URL link frame": "WXXX",
+}
+
+// URLFrame is a W*** link frame, the URL is always in ISO-8859-1
+type URLFrame struct {
+	URL string
+}
+
+func (f URLFrame) Size() int {
+	return len(latin1Bytes(f.URL))
+}
+
+func (f URLFrame) UniqueIdentifier() string {
+	return f.URL
+}
+
+func (f URLFrame) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(latin1Bytes(f.URL))
+	return int64(n), err
+}
+
+// UserDefinedURLFrame is a WXXX frame, only the description is encoded
+type UserDefinedURLFrame struct {
+	Encoding    id3v2.Encoding
+	Description string
+	URL         string
+}
+
+func (f UserDefinedURLFrame) body() []byte {
+	data := bytes.Buffer{}
+	data.WriteByte(f.Encoding.Key)
+	data.Write(encodeText(f.Description, f.Encoding))
+	data.Write(f.Encoding.TerminationBytes)
+	data.Write(latin1Bytes(f.URL))
+	return data.Bytes()
+}
+
+func (f UserDefinedURLFrame) Size() int {
+	return len(f.body())
+}
+
+func (f UserDefinedURLFrame) UniqueIdentifier() string {
+	return f.Description
+}
+
+func (f UserDefinedURLFrame) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(f.body())
+	return int64(n), err
+}
+
+// SyncedText is a chunk of synchronised lyrics with its time stamp
+type SyncedText struct {
+	Text      string
+	Timestamp uint32
+}
+
+// SynchronisedLyricsFrame is a SYLT frame
+type SynchronisedLyricsFrame struct {
+	Encoding          id3v2.Encoding
+	Language          string
+	TimestampFormat   byte
+	ContentType       byte
+	ContentDescriptor string
+	SyncedTexts       []SyncedText
+}
+
+func (f SynchronisedLyricsFrame) body() []byte {
+	data := bytes.Buffer{}
+	data.WriteByte(f.Encoding.Key)
+	data.WriteString(f.Language)
+	data.WriteByte(f.TimestampFormat)
+	data.WriteByte(f.ContentType)
+	data.Write(encodeText(f.ContentDescriptor, f.Encoding))
+	data.Write(f.Encoding.TerminationBytes)
+	for _, chunk := range f.SyncedTexts {
+		data.Write(encodeText(chunk.Text, f.Encoding))
+		data.Write(f.Encoding.TerminationBytes)
+		data.Write([]byte{byte(chunk.Timestamp >> 24), byte(chunk.Timestamp >> 16), byte(chunk.Timestamp >> 8),
+			byte(chunk.Timestamp)})
+	}
+	return data.Bytes()
+}
+
+func (f SynchronisedLyricsFrame) Size() int {
+	return len(f.body())
+}
+
+func (f SynchronisedLyricsFrame) UniqueIdentifier() string {
+	return f.Language + f.ContentDescriptor
+}
+
+func (f SynchronisedLyricsFrame) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(f.body())
+	return int64(n), err
+}
+
+// parseSynchronisedLyricsFrame parses SYLT frame body, the layout is the same in all id3v2 versions
+func parseSynchronisedLyricsFrame(body []byte) (id3v2.Framer, bool) {
+	if len(body) < 6 {
+		return nil, false
+	}
+	encoding := encodingByKey(body[0])
+	desc, rest := splitText(body[6:], encoding)
+	frame := SynchronisedLyricsFrame{
+		Encoding:          encoding,
+		Language:          string(body[1:4]),
+		TimestampFormat:   body[4],
+		ContentType:       body[5],
+		ContentDescriptor: decodeText(desc, encoding),
+	}
+	for len(rest) > 0 {
+		var text []byte
+		text, rest = splitText(rest, encoding)
+		if len(rest) < 4 {
+			return nil, false
+		}
+		frame.SyncedTexts = append(frame.SyncedTexts, SyncedText{
+			Text:      decodeText(text, encoding),
+			Timestamp: uint32(rest[0])<<24 | uint32(rest[1])<<16 | uint32(rest[2])<<8 | uint32(rest[3]),
+		})
+		rest = rest[4:]
+	}
+	return frame, true
+}
+
+// GeneralEncapsulatedObjectFrame is a GEOB frame. The mime type is always in ISO-8859-1
+type GeneralEncapsulatedObjectFrame struct {
+	Encoding    id3v2.Encoding
+	MimeType    string
+	Filename    string
+	Description string
+	Object      []byte
+}
+
+func (f GeneralEncapsulatedObjectFrame) body() []byte {
+	data := bytes.Buffer{}
+	data.WriteByte(f.Encoding.Key)
+	data.Write(latin1Bytes(f.MimeType))
+	data.WriteByte(0)
+	data.Write(encodeText(f.Filename, f.Encoding))
+	data.Write(f.Encoding.TerminationBytes)
+	data.Write(encodeText(f.Description, f.Encoding))
+	data.Write(f.Encoding.TerminationBytes)
+	data.Write(f.Object)
+	return data.Bytes()
+}
+
+func (f GeneralEncapsulatedObjectFrame) Size() int {
+	return len(f.body())
+}
+
+func (f GeneralEncapsulatedObjectFrame) UniqueIdentifier() string {
+	return f.Description
+}
+
+func (f GeneralEncapsulatedObjectFrame) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(f.body())
+	return int64(n), err
+}
+
+// parseGeneralEncapsulatedObjectFrame parses GEOB frame body, the layout is the same in all id3v2 versions
+func parseGeneralEncapsulatedObjectFrame(body []byte) (id3v2.Framer, bool) {
+	if len(body) < 1 {
+		return nil, false
+	}
+	encoding := encodingByKey(body[0])
+	mimeType, rest := splitText(body[1:], id3v2.EncodingISO)
+	filename, rest := splitText(rest, encoding)
+	desc, object := splitText(rest, encoding)
+	return GeneralEncapsulatedObjectFrame{
+		Encoding:    encoding,
+		MimeType:    decodeText(mimeType, id3v2.EncodingISO),
+		Filename:    decodeText(filename, encoding),
+		Description: decodeText(desc, encoding),
+		Object:      object,
+	}, true
+}
+
+// parseV2Frame parses frames the library keeps unparsed. Other frames are returned as is
+func parseV2Frame(id string, f id3v2.Framer) id3v2.Framer {
+	unknown, ok := f.(id3v2.UnknownFrame)
+	if !ok {
+		return f
+	}
+	switch id {
+	case "SYLT":
+		if frame, ok := parseSynchronisedLyricsFrame(unknown.Body); ok {
+			return frame
+		}
+	case "GEOB":
+		if frame, ok := parseGeneralEncapsulatedObjectFrame(unknown.Body); ok {
+			return frame
+		}
+	}
+	if frame, ok := parseURLFrame(id, unknown.Body); ok {
+		return frame
+	}
+	return f
+}
+
+// parseURLFrame parses WXXX and W*** frame bodies
+func parseURLFrame(id string, body []byte) (id3v2.Framer, bool) {
+	if id == "WXXX" {
+		if len(body) == 0 {
+			return nil, false
+		}
+		encoding := encodingByKey(body[0])
+		desc, url := splitText(body[1:], encoding)
+		return UserDefinedURLFrame{
+			Encoding:    encoding,
+			Description: decodeText(desc, encoding),
+			URL:         decodeText(url, id3v2.EncodingISO),
+		}, true
+	}
+	if id[0] == 'W' {
+		return URLFrame{URL: decodeText(body, id3v2.EncodingISO)}, true
+	}
+	return nil, false
+}
+
+func encodingByKey(key byte) id3v2.Encoding {
+	for _, enc := range []id3v2.Encoding{id3v2.EncodingUTF16, id3v2.EncodingUTF16BE, id3v2.EncodingUTF8} {
+		if key == enc.Key {
+			return enc
+		}
+	}
+	return id3v2.EncodingISO
+}
+
+// splitText splits data at the first terminator of the given encoding
+func splitText(data []byte, encoding id3v2.Encoding) ([]byte, []byte) {
+	step := len(encoding.TerminationBytes)
+	for i := 0; i+step <= len(data); i += step {
+		if bytes.Equal(data[i:i+step], encoding.TerminationBytes) {
+			return data[:i], data[i+step:]
+		}
+	}
+	return data, nil
+}
+
+// parseTextList parses a frame body holding a list of terminated strings, e.g. of an IPLS frame
+func parseTextList(body []byte) []string {
+	values := []string{}
+	if len(body) == 0 {
+		return values
+	}
+	encoding := encodingByKey(body[0])
+	for data := body[1:]; len(data) > 0; {
+		var value []byte
+		value, data = splitText(data, encoding)
+		values = append(values, decodeText(value, encoding))
+	}
+	return values
+}
+
+// textListBody makes a frame body holding a list of terminated strings
+func textListBody(values []string, encoding id3v2.Encoding) []byte {
+	data := bytes.Buffer{}
+	data.WriteByte(encoding.Key)
+	for _, value := range values {
+		data.Write(encodeText(value, encoding))
+		data.Write(encoding.TerminationBytes)
+	}
+	return data.Bytes()
+}
+
+// decodeText decodes text of a frame, dropping trailing terminators
+func decodeText(data []byte, encoding id3v2.Encoding) string {
+	data, _ = splitText(data, encoding)
+	switch encoding.Key {
+	case id3v2.EncodingUTF8.Key:
+		return string(data)
+	case id3v2.EncodingUTF16.Key:
+		// text without a BOM is big-endian, as the spec says for unmarked utf16
+		res, err := unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewDecoder().Bytes(data)
+		if err == nil {
+			return string(res)
+		}
+	case id3v2.EncodingUTF16BE.Key:
+		res, err := unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewDecoder().Bytes(data)
+		if err == nil {
+			return string(res)
+		}
+	}
+	// latin1 maps bytes to runes one-to-one
+	res := make([]rune, 0, len(data))
+	for _, b := range data {
+		res = append(res, rune(b))
+	}
+	return string(res)
+}
+
+// encodeText encodes text of a frame without a terminator
+func encodeText(s string, encoding id3v2.Encoding) []byte {
+	switch encoding.Key {
+	case id3v2.EncodingUTF8.Key:
+		return []byte(s)
+	case id3v2.EncodingUTF16.Key:
+		return encodeUTF16(s)
+	case id3v2.EncodingUTF16BE.Key:
+		res, _ := unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewEncoder().Bytes([]byte(s))
+		return res
+	}
+	return latin1Bytes(s)
+}
+
+func encodeUTF16(s string) []byte {
+	res, _ := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder().Bytes([]byte(s))
+	return res
+}
+
+// latin1Bytes encodes a string as ISO-8859-1, runes out of its range are replaced with '?'
+func latin1Bytes(s string) []byte {
+	res := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xff {
+			r = '?'
+		}
+		res = append(res, byte(r))
+	}
+	return res
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/bogem/id3v2/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestURLFrames(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "fixed.mp3")
+	assert.NoError(t, copyFileContents("testdata/podenelnik-id3v2.mp3", dst))
+
+	tag, err := id3v2.Open(dst, id3v2.Options{Parse: true})
+	assert.NoError(t, err)
+	tag.AddFrame("WXXX", UserDefinedURLFrame{Encoding: id3v2.EncodingISO, Description: latin1("\xd1\xe0\xe9\xf2"),
+		URL: "http://a.ru/%D1%E0%E9%F2"})
+	tag.AddFrame("WOAF", URLFrame{URL: "http://a.ru/" + latin1("\xd1\xe0\xe9\xf2")})
+	// utf8 written as is next to cp1251 escapes
+	tag.AddFrame("WOAR", URLFrame{URL: latin1("http://a.ru/Сайт") + "?x=%D1%E0"})
+	assert.NoError(t, tag.Save())
+	tag.Close()
+
+	opts := fixOptions{frames: map[string]string{"WXXX": "WXXX", "WOAF": "WOAF", "WOAR": "WOAR"}, normalizeURLs: true}
+	report, err := fixMp3(context.Background(), dst, "", opts)
+	assert.NoError(t, err)
+	assert.Len(t, report.Changes, 4)
+
+	tag, err = id3v2.Open(dst, id3v2.Options{Parse: true})
+	assert.NoError(t, err)
+	defer tag.Close()
+	assert.Equal(t, UserDefinedURLFrame{Encoding: id3v2.EncodingUTF8, Description: "Сайт",
+		URL: "http://a.ru/%D0%A1%D0%B0%D0%B9%D1%82"}, parseV2Frame("WXXX", tag.GetLastFrame("WXXX")))
+	assert.Equal(t, URLFrame{URL: "http://a.ru/%D0%A1%D0%B0%D0%B9%D1%82"}, parseV2Frame("WOAF", tag.GetLastFrame("WOAF")))
+	assert.Equal(t, URLFrame{URL: "http://a.ru/%D0%A1%D0%B0%D0%B9%D1%82?x=%D0%A1%D0%B0"}, parseV2Frame("WOAR", tag.GetLastFrame("WOAR")),
+		"should re-encode cp1251 escapes next to unicode")
+}
+
+func TestDecodeText(t *testing.T) {
+	for _, enc := range []id3v2.Encoding{id3v2.EncodingUTF8, id3v2.EncodingUTF16, id3v2.EncodingUTF16BE} {
+		data := append(encodeText("Сайт", enc), enc.TerminationBytes...)
+		assert.Equal(t, "Сайт", decodeText(data, enc), enc.Name)
+	}
+	assert.Equal(t, "Site", decodeText([]byte("Site\x00"), id3v2.EncodingISO))
+	assert.Equal(t, "Сайт", decodeText([]byte("\x04\x21\x04\x30\x04\x39\x04\x42\x00\x00"), id3v2.EncodingUTF16),
+		"should read utf16 without a BOM as big-endian")
+	assert.Equal(t, "Сайт", decodeText([]byte("\xff\xfe\x21\x04\x30\x04\x39\x04\x42\x04\x00\x00"), id3v2.EncodingUTF16))
+}
+
+func TestSynchronisedLyricsFrame(t *testing.T) {
+	frame := SynchronisedLyricsFrame{Encoding: id3v2.EncodingUTF16, Language: "rus", TimestampFormat: 2, ContentType: 1,
+		ContentDescriptor: "Глава", SyncedTexts: []SyncedText{{"Понедельник", 0}, {"суббота", 70000}}}
+	body := bytes.Buffer{}
+	n, err := frame.WriteTo(&body)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(frame.Size()), n)
+
+	parsed := parseV2Frame("SYLT", id3v2.UnknownFrame{Body: body.Bytes()})
+	assert.Equal(t, frame, parsed)
+
+	_, ok := parseSynchronisedLyricsFrame(body.Bytes()[:body.Len()-1])
+	assert.False(t, ok, "should fail on truncated time stamp")
+}
+
+func TestFixMp3_PictureUnchanged(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "fixed.mp3")
+	assert.NoError(t, copyFileContents("testdata/podenelnik-id3v2.mp3", dst))
+	picture := make([]byte, 4096)
+	for i := range picture {
+		picture[i] = byte(i * 7)
+	}
+	object := []byte("\xce\xe1\xeb\xee\xe6\xea\xe0\x00\x00\xff")
+
+	tag, err := id3v2.Open(dst, id3v2.Options{Parse: true})
+	assert.NoError(t, err)
+	tag.AddAttachedPicture(id3v2.PictureFrame{Encoding: id3v2.EncodingISO, MimeType: "image/jpeg",
+		PictureType: id3v2.PTFrontCover, Description: latin1("\xce\xe1\xeb\xee\xe6\xea\xe0"), Picture: picture})
+	tag.AddFrame("GEOB", GeneralEncapsulatedObjectFrame{Encoding: id3v2.EncodingISO, MimeType: "application/octet-stream",
+		Filename: latin1("\xce\xe1\xeb\xee\xe6\xea\xe0.bin"), Description: "cover", Object: object})
+	assert.NoError(t, tag.Save())
+	tag.Close()
+
+	opts := fixOptions{frames: map[string]string{"APIC": "APIC", "GEOB": "GEOB"}}
+	report, err := fixMp3(context.Background(), dst, "", opts)
+	assert.NoError(t, err)
+	assert.Len(t, report.Changes, 2)
+
+	tag, err = id3v2.Open(dst, id3v2.Options{Parse: true})
+	assert.NoError(t, err)
+	defer tag.Close()
+	pictures := tag.GetFrames("APIC")
+	if assert.Len(t, pictures, 1) {
+		assert.Equal(t, "Обложка", pictures[0].(id3v2.PictureFrame).Description)
+		assert.Equal(t, picture, pictures[0].(id3v2.PictureFrame).Picture)
+	}
+	geob := parseV2Frame("GEOB", tag.GetLastFrame("GEOB")).(GeneralEncapsulatedObjectFrame)
+	assert.Equal(t, "Обложка.bin", geob.Filename)
+	assert.Equal(t, "application/octet-stream", geob.MimeType)
+	assert.Equal(t, object, geob.Object)
+}
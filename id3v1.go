@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	id3v1 "github.com/frolovo22/tag"
+)
+
+// encodeTagV1 encodes an id3v1 tag. The library writer breaks non-ascii text, as it takes strings for utf8, so
+// fields are written as raw bytes here
+func encodeTagV1(file *id3v1.ID3v1) []byte {
+	data := make([]byte, id3v1TagSize)
+	copy(data[0:3], "TAG")
+	copy(data[3:33], file.Title)
+	copy(data[33:63], file.Artist)
+	copy(data[63:93], file.Album)
+	copy(data[93:97], fmt.Sprintf("%04d", file.Year))
+	if commentSizeV1(file) == 28 {
+		// id3v1.1, the last two bytes of the comment hold a track number
+		copy(data[97:125], file.Comment)
+		data[126] = file.Track
+	} else {
+		copy(data[97:127], file.Comment)
+	}
+	data[127] = byte(file.Genre)
+	return data
+}
+
+// commentSizeV1 returns the maximum comment length: 28 bytes if there is an id3v1.1 track number, 30 otherwise
+func commentSizeV1(file *id3v1.ID3v1) int {
+	if trackV1(file) != 0 {
+		return 28
+	}
+	return 30
+}
+
+// trackV1 returns the id3v1.1 track number, 0 if there is none
+func trackV1(file *id3v1.ID3v1) byte {
+	if file.ZeroByte == 0 {
+		return file.Track
+	}
+	return 0
+}
+
+// genre byte of id3v1 tags without a genre
+const noGenreV1 id3v1.Genre = 255
+
+// genreNameV1 maps an id3v1 genre byte to its name, empty if there is no genre. Genres missing from the list are
+// shown as numbers
+func genreNameV1(g id3v1.Genre) string {
+	if g == noGenreV1 {
+		return ""
+	}
+	if name := g.String(); name != "" {
+		return name
+	}
+	return strconv.Itoa(int(g))
+}
+
+// knownGenreV1 checks if a genre byte is in the list of id3v1 genres (with Winamp extensions)
+func knownGenreV1(g id3v1.Genre) bool {
+	return g.String() != ""
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+	"github.com/rs/zerolog"
+)
+
+// id3v2.2 frames are mostly renamed id3v2.3 frames, see https://id3.org/id3v2-00
+var v22ToV23IDs = map[string]string{
+	"BUF": "RBUF", "CNT": "PCNT", "COM": "COMM", "CRA": "AENC", "ETC": "ETCO", "EQU": "EQUA",
+	"GEO": "GEOB", "IPL": "IPLS", "LNK": "LINK", "MCI": "MCDI", "MLL": "MLLT", "PIC": "APIC",
+	"POP": "POPM", "REV": "RVRB", "RVA": "RVAD", "SLT": "SYLT", "STC": "SYTC", "TAL": "TALB",
+	"TBP": "TBPM", "TCM": "TCOM", "TCO": "TCON", "TCR": "TCOP", "TDA": "TDAT", "TDY": "TDLY",
+	"TEN": "TENC", "TFT": "TFLT", "TIM": "TIME", "TKE": "TKEY", "TLA": "TLAN", "TLE": "TLEN",
+	"TMT": "TMED", "TOA": "TOPE", "TOF": "TOFN", "TOL": "TOLY", "TOR": "TORY", "TOT": "TOAL",
+	"TP1": "TPE1", "TP2": "TPE2", "TP3": "TPE3", "TP4": "TPE4", "TPA": "TPOS", "TPB": "TPUB",
+	"TRC": "TSRC", "TRD": "TRDA", "TRK": "TRCK", "TSI": "TSIZ", "TSS": "TSSE", "TT1": "TIT1",
+	"TT2": "TIT2", "TT3": "TIT3", "TXT": "TEXT", "TXX": "TXXX", "TYE": "TYER", "UFI": "UFID",
+	"ULT": "USLT", "WAF": "WOAF", "WAR": "WOAR", "WAS": "WOAS", "WCM": "WCOM", "WCP": "WCOP",
+	"WPB": "WPUB", "WXX": "WXXX",
+}
+
+const v22HeaderSize = 10
+
+var errV22Unsupported = errors.New("unsynchronised or compressed id3v2.2 tags are not supported")
+
+// v22Frame is a raw id3v2.2 frame
+type v22Frame struct {
+	ID   string
+	Data []byte
+}
+
+// v22Tag is a raw id3v2.2 tag
+type v22Tag struct {
+	Frames []v22Frame
+	Size   int64 // original tag size including the header
+}
+
+// readV22Tag reads a raw id3v2.2 tag from the beginning of a file
+func readV22Tag(r io.Reader) (*v22Tag, error) {
+	header := make([]byte, v22HeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[0:3]) != "ID3" || header[3] != 2 {
+		return nil, errors.New("not an id3v2.2 tag")
+	}
+	// bit 7 is unsynchronisation, bit 6 is compression
+	if header[5]&0xc0 != 0 {
+		return nil, errV22Unsupported
+	}
+	size := synchsafeToInt(header[6:10])
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("failed reading id3v2.2 frames: %w", err)
+	}
+	tag := &v22Tag{Size: int64(v22HeaderSize + size)}
+	for len(body) >= 6 {
+		id := string(body[0:3])
+		if body[0] == 0 {
+			// padding
+			break
+		}
+		frameSize := int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		if 6+frameSize > len(body) {
+			return nil, fmt.Errorf("frame %s went over tag area", id)
+		}
+		tag.Frames = append(tag.Frames, v22Frame{ID: id, Data: body[6 : 6+frameSize]})
+		body = body[6+frameSize:]
+	}
+	return tag, nil
+}
+
+// WriteTo writes an id3v2.2 tag without padding
+func (t *v22Tag) WriteTo(w io.Writer) (int64, error) {
+	body := bytes.Buffer{}
+	for _, f := range t.Frames {
+		size := len(f.Data)
+		if size > 0xffffff {
+			return 0, fmt.Errorf("frame %s is too big for id3v2.2", f.ID)
+		}
+		body.WriteString(f.ID)
+		body.Write([]byte{byte(size >> 16), byte(size >> 8), byte(size)})
+		body.Write(f.Data)
+	}
+	header := []byte{'I', 'D', '3', 2, 0, 0}
+	header = append(header, intToSynchsafe(body.Len())...)
+	n, err := w.Write(header)
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := body.WriteTo(w)
+	return int64(n) + m, err
+}
+
+// toFramer converts text, comment, lyrics, link, picture and object id3v2.2 frames into id3v2 framers,
+// so they could be fixed the same way
+func (f v22Frame) toFramer() (id3v2.Framer, error) {
+	if len(f.Data) == 0 {
+		return nil, errors.New("empty frame")
+	}
+	encoding := encodingByKey(f.Data[0])
+	switch {
+	case f.ID == "TXX":
+		desc, value := splitText(f.Data[1:], encoding)
+		return id3v2.UserDefinedTextFrame{
+			Encoding:    encoding,
+			Description: decodeText(desc, encoding),
+			Value:       decodeText(value, encoding),
+		}, nil
+	case f.ID[0] == 'T':
+		return id3v2.TextFrame{Encoding: encoding, Text: decodeText(f.Data[1:], encoding)}, nil
+	case f.ID == "COM":
+		if len(f.Data) < 4 {
+			return nil, errors.New("comment frame is too short")
+		}
+		desc, text := splitText(f.Data[4:], encoding)
+		return id3v2.CommentFrame{
+			Encoding:    encoding,
+			Language:    string(f.Data[1:4]),
+			Description: decodeText(desc, encoding),
+			Text:        decodeText(text, encoding),
+		}, nil
+	case f.ID == "ULT":
+		if len(f.Data) < 4 {
+			return nil, errors.New("lyrics frame is too short")
+		}
+		desc, lyrics := splitText(f.Data[4:], encoding)
+		return id3v2.UnsynchronisedLyricsFrame{
+			Encoding:          encoding,
+			Language:          string(f.Data[1:4]),
+			ContentDescriptor: decodeText(desc, encoding),
+			Lyrics:            decodeText(lyrics, encoding),
+		}, nil
+	case f.ID == "SLT":
+		if frame, ok := parseSynchronisedLyricsFrame(f.Data); ok {
+			return frame, nil
+		}
+		return nil, errors.New("malformed synchronised lyrics frame")
+	case f.ID == "GEO":
+		frame, _ := parseGeneralEncapsulatedObjectFrame(f.Data)
+		return frame, nil
+	case f.ID == "PIC":
+		return f.toPictureFrame()
+	case f.ID[0] == 'W':
+		if frame, ok := parseURLFrame(v22ToV23IDs[f.ID], f.Data); ok {
+			return frame, nil
+		}
+	}
+	return nil, errors.New("failed to detect frame type")
+}
+
+// toPictureFrame converts PIC frame to APIC, image format is converted to a mime type
+func (f v22Frame) toPictureFrame() (id3v2.Framer, error) {
+	if len(f.Data) < 5 {
+		return nil, errors.New("picture frame is too short")
+	}
+	encoding := encodingByKey(f.Data[0])
+	mimeType := "image/" + strings.ToLower(string(f.Data[1:4]))
+	if mimeType == "image/jpg" {
+		mimeType = "image/jpeg"
+	}
+	desc, picture := splitText(f.Data[5:], encoding)
+	return id3v2.PictureFrame{
+		Encoding:    encoding,
+		MimeType:    mimeType,
+		PictureType: f.Data[4],
+		Description: decodeText(desc, encoding),
+		Picture:     picture,
+	}, nil
+}
+
+// v22ImageFormat converts a mime type back to a 3 letter image format of PIC frame
+func v22ImageFormat(mimeType string) string {
+	format := strings.ToUpper(strings.TrimPrefix(mimeType, "image/"))
+	if format == "JPEG" {
+		format = "JPG"
+	}
+	return (format + "   ")[:3]
+}
+
+// toV22Frame encodes a fixed framer back into an id3v2.2 frame. There is no utf8 in id3v2.2,
+// so the text is written as utf16 with BOM
+func toV22Frame(id string, framer id3v2.Framer) (v22Frame, error) {
+	if v, ok := framer.(URLFrame); ok {
+		return v22Frame{ID: id, Data: latin1Bytes(v.URL)}, nil
+	}
+	data := bytes.Buffer{}
+	data.WriteByte(id3v2.EncodingUTF16.Key)
+	switch v := framer.(type) {
+	case UserDefinedURLFrame:
+		data.Write(encodeUTF16(v.Description))
+		data.Write(id3v2.EncodingUTF16.TerminationBytes)
+		data.Write(latin1Bytes(v.URL))
+	case id3v2.UserDefinedTextFrame:
+		data.Write(encodeUTF16(v.Description))
+		data.Write(id3v2.EncodingUTF16.TerminationBytes)
+		data.Write(encodeUTF16(v.Value))
+	case id3v2.TextFrame:
+		data.Write(encodeUTF16(v.Text))
+	case id3v2.CommentFrame:
+		data.WriteString(v.Language)
+		data.Write(encodeUTF16(v.Description))
+		data.Write(id3v2.EncodingUTF16.TerminationBytes)
+		data.Write(encodeUTF16(v.Text))
+	case id3v2.UnsynchronisedLyricsFrame:
+		data.WriteString(v.Language)
+		data.Write(encodeUTF16(v.ContentDescriptor))
+		data.Write(id3v2.EncodingUTF16.TerminationBytes)
+		data.Write(encodeUTF16(v.Lyrics))
+	case SynchronisedLyricsFrame:
+		v.Encoding = id3v2.EncodingUTF16
+		return v22Frame{ID: id, Data: v.body()}, nil
+	case GeneralEncapsulatedObjectFrame:
+		v.Encoding = id3v2.EncodingUTF16
+		return v22Frame{ID: id, Data: v.body()}, nil
+	case id3v2.PictureFrame:
+		data.WriteString(v22ImageFormat(v.MimeType))
+		data.WriteByte(v.PictureType)
+		data.Write(encodeUTF16(v.Description))
+		data.Write(id3v2.EncodingUTF16.TerminationBytes)
+		data.Write(v.Picture)
+	default:
+		return v22Frame{}, fmt.Errorf("frame %s can not be written as id3v2.2", id)
+	}
+	return v22Frame{ID: id, Data: data.Bytes()}, nil
+}
+
+func synchsafeToInt(b []byte) int {
+	res := 0
+	for _, v := range b {
+		res = res<<7 | int(v&0x7f)
+	}
+	return res
+}
+
+func intToSynchsafe(n int) []byte {
+	res := make([]byte, 4)
+	for i := 3; i >= 0; i-- {
+		res[i] = byte(n & 0x7f)
+		n >>= 7
+	}
+	return res
+}
+
+// fixTagsV22 fixes id3v2.2 tags. Frames are converted to their id3v2.3 counterparts, fixed the same way as in
+// fixTagsV23 and written back as id3v2.2. If opts.upgradeV22 or opts.targetVersion is set, the tag is upgraded
+// to the target version, id3v2.4 by default
+func fixTagsV22(ctx context.Context, fileName string, opts fixOptions, report *FileReport) error {
+	logger := zerolog.Ctx(ctx)
+	if len(opts.frames) == 0 {
+		return errors.New("no frames to fix given")
+	}
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return fmt.Errorf("failed opening mp3 file for reading: %w", err)
+	}
+	tag, err := readV22Tag(fh)
+	fh.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read id3v2.2 tags: %w", err)
+	}
+	wanted := make(map[string]bool, len(opts.frames))
+	for _, id := range opts.frames {
+		wanted[id] = true
+	}
+
+	upgrade := opts.upgradeV22 || opts.targetVersion != 0
+	version := opts.targetVersion
+	if version == 0 {
+		version = 4
+	}
+	// there is no utf8 in id3v2.2 either
+	encoding := id3v2.EncodingUTF16
+	if upgrade {
+		encoding = textEncoding(version)
+	}
+
+	totalErrorsCount := 0
+	totalFixedCount := 0
+	fixed := make(map[int]id3v2.Framer)
+	indexes := make(map[string]int)
+	for n, f := range tag.Frames {
+		// frames are reported by their id3v2.3 ids, so -frames works the same for all versions
+		id, ok := v22ToV23IDs[f.ID]
+		if !ok || !wanted[id] {
+			continue
+		}
+		i := indexes[id]
+		indexes[id] += 1
+		fixedFrame, fixes, err := fixV22Frame(f, opts.decoding(), encoding, opts.normalizeURLs)
+		if err != nil {
+			logger.Warn().Err(err).Msgf("Failed to fix frame %s#%d, leaving it as is", id, i)
+			report.Errors = append(report.Errors, fmt.Sprintf("%s#%d: %s", id, i, err))
+			totalErrorsCount += 1
+			continue
+		}
+		if fixes == nil {
+			logger.Debug().Msgf("Skipping zero difference fix for frame %s#%d", id, i)
+			continue
+		}
+		fields := make([]string, 0, len(fixes))
+		for field := range fixes {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			change := fixes[field]
+			logger.Info().Msgf("Fixed frame %s#%d.%s: %s -> %s (charset %s, confidence %.2f)",
+				id, i, field, change.Old, change.New, change.Charset, change.Confidence)
+			report.Changes = append(report.Changes, FrameChange{Frame: id, Index: i, Field: field, Change: change})
+		}
+		totalFixedCount += 1
+		fixed[n] = fixedFrame
+	}
+
+	if totalErrorsCount > 0 {
+		if !opts.forced {
+			return fmt.Errorf("got %d error(s) while fixing encoding and aborted", totalErrorsCount)
+		}
+		logger.Error().Msgf("Got %d errors(s) while fixing encoding, proceeding", totalErrorsCount)
+	}
+
+	var newTag io.WriterTo
+	if upgrade {
+		v2Tag, dropped := v22ToV2Tag(tag.Frames, fixed, version)
+		for _, id := range dropped {
+			logger.Warn().Msgf("Frame %s has no id3v2.%d counterpart, dropping it", id, version)
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: dropped while upgrading to id3v2.%d", id, version))
+		}
+		newTag = v2Tag
+	} else {
+		for n, frame := range fixed {
+			v22Frame, err := toV22Frame(tag.Frames[n].ID, frame)
+			if err != nil {
+				return err
+			}
+			tag.Frames[n] = v22Frame
+		}
+		newTag = tag
+	}
+
+	err = rewriteTag(fileName, tag.Size, newTag)
+	if err != nil {
+		return fmt.Errorf("failed saving temp file: %w", err)
+	}
+	logger.Info().Msgf("Fixed %d frame(s)", totalFixedCount)
+
+	return nil
+}
+
+// fixV22Frame decodes a raw id3v2.2 frame and fixes it with fixV2Frame
+func fixV22Frame(f v22Frame, dec decoding, enc id3v2.Encoding, normalizeURLs bool) (id3v2.Framer, map[string]Change, error) {
+	frame, err := f.toFramer()
+	if err != nil {
+		return nil, nil, err
+	}
+	return fixV2Frame(frame, dec, enc, normalizeURLs)
+}
+
+// rewriteTag replaces the first tagSize bytes of a file with a new tag
+func rewriteTag(fileName string, tagSize int64, tag io.WriterTo) error {
+	src, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if _, err := src.Seek(tagSize, io.SeekStart); err != nil {
+		return err
+	}
+
+	newName := fileName + "-id3v22"
+	dst, err := os.Create(newName)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(newName)
+	if _, err := tag.WriteTo(dst); err != nil {
+		dst.Close()
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	src.Close()
+	return os.Rename(newName, fileName)
+}
+
+// v22ToV2Tag builds an id3v2.3 or id3v2.4 tag out of id3v2.2 frames. Frames with unknown ids, including
+// encrypted CRM frames, and LNK frames linking to such frames are dropped, their ids are returned
+func v22ToV2Tag(frames []v22Frame, fixed map[int]id3v2.Framer, version byte) (*id3v2.Tag, []string) {
+	tag := id3v2.NewEmptyTag()
+	tag.SetVersion(3)
+	dropped := []string{}
+	for i, f := range frames {
+		id, ok := v22ToV23IDs[f.ID]
+		if !ok {
+			dropped = append(dropped, f.ID)
+			continue
+		}
+		framer, ok := fixed[i]
+		if f.ID == "LNK" {
+			body, ok := v22LinkToV2(f.Data)
+			if !ok {
+				dropped = append(dropped, f.ID)
+				continue
+			}
+			framer = id3v2.UnknownFrame{Body: body}
+		} else if !ok {
+			var err error
+			framer, err = f.toFramer()
+			if err != nil {
+				// keep as is, binary layout of other frames did not change
+				framer = id3v2.UnknownFrame{Body: f.Data}
+			}
+		}
+		tag.AddFrame(id, framer)
+	}
+	convertTagVersion(tag, version)
+	return tag, dropped
+}
+
+// v22LinkToV2 replaces the 3 character id of a linked frame in LNK body with the id3v2.3 one
+func v22LinkToV2(data []byte) ([]byte, bool) {
+	if len(data) < 3 {
+		return nil, false
+	}
+	id, ok := v22ToV23IDs[string(data[:3])]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(id), data[3:]...), true
+}
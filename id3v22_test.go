@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bogem/id3v2/v2"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/charmap"
+)
+
+var fakeAudio = []byte{0xff, 0xfb, 0x90, 0x64, 0x00, 0x00, 0x00, 0x00}
+
+func cp1251Frame(t *testing.T, id string, prefix []byte, texts ...string) v22Frame {
+	data := append([]byte{0}, prefix...)
+	for i, text := range texts {
+		b, err := charmap.Windows1251.NewEncoder().Bytes([]byte(text))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		if i > 0 {
+			data = append(data, 0)
+		}
+		data = append(data, b...)
+	}
+	return v22Frame{ID: id, Data: data}
+}
+
+func makeV22File(t *testing.T) string {
+	tag := v22Tag{Frames: []v22Frame{
+		cp1251Frame(t, "TT2", nil, "Понедельник"),
+		cp1251Frame(t, "TP1", nil, "Стругацкие"),
+		{ID: "TYE", Data: []byte("\x002005")},
+		cp1251Frame(t, "COM", []byte("rus"), "", "Говорящая книга"),
+		cp1251Frame(t, "TXX", nil, "Reader", "Шарыгин"),
+		{ID: "XYZ", Data: []byte{1, 2, 3}},
+	}}
+	buf := bytes.Buffer{}
+	_, err := tag.WriteTo(&buf)
+	assert.NoError(t, err)
+	// padding
+	buf.Write(make([]byte, 16))
+	raw := buf.Bytes()
+	copy(raw[6:10], intToSynchsafe(len(raw)-v22HeaderSize))
+	raw = append(raw, fakeAudio...)
+
+	name := filepath.Join(t.TempDir(), "id3v22.mp3")
+	assert.NoError(t, os.WriteFile(name, raw, 0644))
+	return name
+}
+
+func TestFixMp3_Id3V22(t *testing.T) {
+	src := makeV22File(t)
+	dst := filepath.Join(t.TempDir(), "fixed.mp3")
+
+	report, err := fixMp3(context.Background(), src, dst, fixOptions{frames: supportedV2Frames(), forced: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "id3v2.2", report.Version)
+	assert.Len(t, report.Changes, 4)
+
+	fh, err := os.Open(dst)
+	assert.NoError(t, err)
+	defer fh.Close()
+	tag, err := readV22Tag(fh)
+	assert.NoError(t, err)
+	assert.Len(t, tag.Frames, 6)
+
+	texts := map[string]string{}
+	for _, f := range tag.Frames[:5] {
+		framer, err := f.toFramer()
+		assert.NoError(t, err)
+		switch v := framer.(type) {
+		case id3v2.TextFrame:
+			texts[f.ID] = v.Text
+		case id3v2.CommentFrame:
+			texts[f.ID] = v.Text
+		case id3v2.UserDefinedTextFrame:
+			texts[f.ID] = v.Description + "=" + v.Value
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"TT2": "Понедельник",
+		"TP1": "Стругацкие",
+		"TYE": "2005",
+		"COM": "Говорящая книга",
+		"TXX": "Reader=Шарыгин",
+	}, texts)
+	// unknown frames are kept as is
+	assert.Equal(t, v22Frame{ID: "XYZ", Data: []byte{1, 2, 3}}, tag.Frames[5])
+
+	audio := make([]byte, len(fakeAudio)+1)
+	n, _ := fh.Read(audio)
+	assert.Equal(t, fakeAudio, audio[:n])
+}
+
+func TestFixMp3_Id3V22Upgrade(t *testing.T) {
+	src := makeV22File(t)
+	dst := filepath.Join(t.TempDir(), "fixed.mp3")
+
+	report, err := fixMp3(context.Background(), src, dst, fixOptions{frames: supportedV2Frames(), forced: true, upgradeV22: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "id3v2.2", report.Version)
+	assert.Len(t, report.Changes, 4)
+	assert.Equal(t, []string{"XYZ: dropped while upgrading to id3v2.4"}, report.Errors)
+
+	tag, err := id3v2.Open(dst, id3v2.Options{Parse: true})
+	assert.NoError(t, err)
+	defer tag.Close()
+	assert.Equal(t, byte(4), tag.Version())
+	assert.Equal(t, "Понедельник", tag.Title())
+	assert.Equal(t, "Стругацкие", tag.Artist())
+	assert.Equal(t, "2005", tag.GetTextFrame("TDRC").Text)
+	comments := tag.GetFrames("COMM")
+	if assert.Len(t, comments, 1) {
+		assert.Equal(t, "Говорящая книга", comments[0].(id3v2.CommentFrame).Text)
+	}
+	txxx := tag.GetFrames("TXXX")
+	if assert.Len(t, txxx, 1) {
+		assert.Equal(t, "Шарыгин", txxx[0].(id3v2.UserDefinedTextFrame).Value)
+	}
+}
+
+func TestV22ToV2Tag_Links(t *testing.T) {
+	frames := []v22Frame{
+		{ID: "LNK", Data: []byte("TT2http://a.ru/tags\x00")},
+		{ID: "LNK", Data: []byte("XYZhttp://a.ru/tags\x00")},
+		{ID: "CRM", Data: []byte("owner\x00desc\x00data")},
+	}
+	tag, dropped := v22ToV2Tag(frames, map[int]id3v2.Framer{}, 4)
+	assert.Equal(t, []string{"LNK", "CRM"}, dropped, "should drop links to unknown frames and encrypted frames")
+	links := tag.GetFrames("LINK")
+	if assert.Len(t, links, 1) {
+		assert.Equal(t, []byte("TIT2http://a.ru/tags\x00"), links[0].(id3v2.UnknownFrame).Body,
+			"should convert the id of a linked frame")
+	}
+	assert.Empty(t, tag.GetFrames("LNK"))
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,17 +20,53 @@ const Version = "0.2.1"
 
 type framesMap map[string]string
 
+// listValue is a comma-separated list cmdline option
+type listValue []string
+
+// versionValue is an id3v2 version cmdline option, 0 means keep the original version
+type versionValue byte
+
+// chainsValue is a comma-separated list of mangling chains cmdline option
+type chainsValue []manglingChain
+
+// translitValue is a transliteration scheme cmdline option
+type translitValue translitScheme
+
+// charsetValue is a source code page cmdline option, nil charset means autodetect
+type charsetValue struct {
+	charset *charset
+}
+
 type optionsType struct {
-	src          string
-	sources      []string
-	dst          string
-	frames       framesMap
-	listV2Frames bool
-	forced       bool
-	verbose      bool
-	vverbose     bool
-	version      bool
-	help         bool
+	src           string
+	sources       []string
+	dst           string
+	frames        framesMap
+	charset       charsetValue
+	listV2Frames  bool
+	forced        bool
+	dryRun        bool
+	walk          walkOptions
+	jobs          int
+	report        string
+	backup        backupOptions
+	noPreserve    bool
+	upgradeV22    bool
+	v1Mode        v1Mode
+	v1Text        v1Text
+	v1Ellipsis    bool
+	v1Overflow    bool
+	v1TrackGenre  bool
+	v1ResetGenre  bool
+	targetVersion versionValue
+	iri           bool
+	chains        chainsValue
+	translit      translitValue
+	translitMap   string
+	verbose       bool
+	vverbose      bool
+	version       bool
+	help          bool
 }
 
 // sets frames to fix cmdline option
@@ -70,22 +109,159 @@ func (f *framesMap) String() string {
 	for _, id := range *f {
 		t = append(t, id)
 	}
+	sort.Strings(t)
 	return strings.Join(t, ",")
 }
 
+// sets comma-separated list cmdline option
+func (l *listValue) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// reads comma-separated list cmdline option as a string
+func (l *listValue) String() string {
+	return strings.Join(*l, ",")
+}
+
+// sets source code page cmdline option
+func (c *charsetValue) Set(value string) error {
+	if value == "auto" {
+		c.charset = nil
+		return nil
+	}
+	cs, err := lookupCharset(value)
+	if err != nil {
+		return err
+	}
+	c.charset = &cs
+	return nil
+}
+
+// reads source code page cmdline option as a string
+func (c *charsetValue) String() string {
+	if c.charset == nil {
+		return "auto"
+	}
+	return c.charset.Name
+}
+
+// sets mangling chains cmdline option
+func (c *chainsValue) Set(value string) error {
+	chains := chainsValue{}
+	for _, name := range strings.Split(value, ",") {
+		chain, err := lookupManglingChain(name)
+		if err != nil {
+			return err
+		}
+		chains = append(chains, chain)
+	}
+	*c = chains
+	return nil
+}
+
+// reads mangling chains cmdline option as a string
+func (c *chainsValue) String() string {
+	names := make([]string, 0, len(*c))
+	for _, chain := range *c {
+		names = append(names, chain.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// sets transliteration scheme cmdline option
+func (t *translitValue) Set(value string) error {
+	scheme, err := lookupTranslitScheme(value)
+	if err != nil {
+		return err
+	}
+	*t = translitValue(scheme)
+	return nil
+}
+
+// reads transliteration scheme cmdline option as a string
+func (t *translitValue) String() string {
+	return t.Name
+}
+
+// sets id3v2 version cmdline option
+func (v *versionValue) Set(value string) error {
+	switch value {
+	case "keep":
+		*v = 0
+	case "3", "4":
+		*v = versionValue(value[0] - '0')
+	default:
+		return fmt.Errorf("unsupported id3v2 version %s, expected one of: keep, 3, 4", value)
+	}
+	return nil
+}
+
+// reads id3v2 version cmdline option as a string
+func (v *versionValue) String() string {
+	if *v == 0 {
+		return "keep"
+	}
+	return strconv.Itoa(int(*v))
+}
+
+var v1ModeNames = []string{v1Fix: "fix", v1Regenerate: "regenerate", v1Strip: "strip"}
+
+// sets id3v1 trailers handling cmdline option
+func (m *v1Mode) Set(value string) error {
+	for mode, name := range v1ModeNames {
+		if value == name {
+			*m = v1Mode(mode)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown id3v1 mode %s, expected one of: %s", value, strings.Join(v1ModeNames, ", "))
+}
+
+// reads id3v1 trailers handling cmdline option as a string
+func (m *v1Mode) String() string {
+	return v1ModeNames[*m]
+}
+
+var v1TextNames = []string{v1Translit: "translit", v1Cp1251: "cp1251", v1ToV2: "v2"}
+
+// sets id3v1 text cmdline option
+func (t *v1Text) Set(value string) error {
+	for mode, name := range v1TextNames {
+		if value == name {
+			*t = v1Text(mode)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown id3v1 text mode %s, expected one of: %s", value, strings.Join(v1TextNames, ", "))
+}
+
+// reads id3v1 text cmdline option as a string
+func (t *v1Text) String() string {
+	return v1TextNames[*t]
+}
+
 func main() {
-	options := parseCmdlineOptions()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "restore":
+			os.Exit(restoreCmd(os.Args[2:]))
+		case "backups":
+			os.Exit(backupsCmd(os.Args[2:]))
+		}
+	}
 
-	if options.vverbose {
-		zerolog.SetGlobalLevel(zerolog.TraceLevel)
-	} else if options.verbose {
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	} else {
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	options := parseCmdlineOptions()
+	if options.report == "-" {
+		// keep stdout clean for json lines
+		logOutput = os.Stderr
 	}
-	consoleWriter := zerolog.NewConsoleWriter()
-	consoleWriter.TimeFormat = time.DateTime
-	log.Logger = zerolog.New(consoleWriter).With().Timestamp().Logger()
+	setupLogger(options.verbose, options.vverbose)
 
 	if options.listV2Frames {
 		fmt.Println("Suported id3v2 frames:")
@@ -103,41 +279,94 @@ func main() {
 		fmt.Printf("       %s -src <source_file.mp3> [-dst <destination_file.mp3>]\n", progname)
 		fmt.Printf("or\n")
 		fmt.Printf("       %s <source_file 1.mp3> [<source_file 2.mp3> ...]\n", progname)
+		fmt.Printf("or\n")
+		fmt.Printf("       %s -r <directory> [<directory 2> ...]\n", progname)
+		fmt.Printf("or\n")
+		fmt.Printf("       %s restore [-t <timestamp>] [-delete] <source_file.mp3> ...\n", progname)
+		fmt.Printf("or\n")
+		fmt.Printf("       %s backups prune -older-than <age> <source_file.mp3> ...\n", progname)
 		fmt.Println("Arguments:")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	errCnt := 0
+	if err := options.backup.validate(); err != nil {
+		log.Error().Err(err).Msg("")
+		os.Exit(1)
+	}
+	tr := transliterator{scheme: translitScheme(options.translit)}
+	if options.translitMap != "" {
+		overrides, err := loadTranslitMap(options.translitMap)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to read transliteration table")
+			os.Exit(1)
+		}
+		tr.overrides = overrides
+	}
+
+	fixOpts := fixOptions{
+		frames:  options.frames,
+		forced:  options.forced,
+		charset: options.charset.charset,
+		dryRun:  options.dryRun,
+		backup:  options.backup,
+
+		preserve:   !options.noPreserve,
+		upgradeV22: options.upgradeV22,
+		v1Mode:     options.v1Mode,
+		v1Text:     options.v1Text,
+		v1Ellipsis: options.v1Ellipsis,
+		v1Overflow: options.v1Overflow,
+
+		v1TrackGenre:  options.v1TrackGenre,
+		v1ResetGenre:  options.v1ResetGenre,
+		targetVersion: byte(options.targetVersion),
+		normalizeURLs: options.iri,
+		chains:        options.chains,
+		translit:      tr,
+	}
+
 	if len(options.sources) > 0 {
-		fixedCnt := 0
-		for _, src := range options.sources {
-			log.Info().Msgf("Fixing %s...", src)
-			err := fixMp3(src, "", options.frames, options.forced)
-			if err != nil {
-				log.Error().Err(err).Msg("")
-				// for debug purposes
-				if unwrapped := errors.Unwrap(err); unwrapped != nil {
-					log.Error().Err(unwrapped).Msg("Unwrapped error")
-				}
-				errCnt += 1
-				if !options.forced {
-					log.Error().Msg("Aborting...")
-					break
-				}
-			} else {
-				fixedCnt += 1
-			}
+		sources, err := collectSources(options.sources, options.walk)
+		if err != nil {
+			log.Error().Err(err).Msg("")
+			os.Exit(1)
 		}
+		options.sources = sources
+	}
+
+	var reports *reportWriter
+	if options.report != "" {
+		var err error
+		reports, err = newReportWriter(options.report)
+		if err != nil {
+			log.Error().Err(err).Msg("")
+			os.Exit(1)
+		}
+	}
+
+	errCnt := 0
+	if len(options.sources) > 0 {
+		var fixedCnt int
+		fixedCnt, errCnt = fixBatch(options.sources, fixOpts, options.jobs, reports)
 		log.Info().Msgf("Fixed %d/%d files", fixedCnt, len(options.sources))
 	} else {
-		err := fixMp3(options.src, options.dst, options.frames, options.forced)
+		report, err := fixMp3(log.Logger.WithContext(context.Background()), options.src, options.dst, fixOpts)
 		if err != nil {
-			log.Error().Err(err).Msg("")
-			// for debug purposes
-			if unwrapped := errors.Unwrap(err); unwrapped != nil {
-				log.Error().Err(unwrapped).Msg("Unwrapped error")
+			logError(log.Logger, err)
+			errCnt += 1
+		} else if options.dryRun && options.report != "-" {
+			printChanges(options.src, report.Changes)
+		}
+		if reports != nil {
+			if err := reports.Add(report); err != nil {
+				log.Error().Err(err).Msg("Failed writing report")
 			}
+		}
+	}
+	if reports != nil {
+		if err := reports.Close(); err != nil {
+			log.Error().Err(err).Msg("Failed writing report")
 			errCnt += 1
 		}
 	}
@@ -146,13 +375,88 @@ func main() {
 	}
 }
 
+// logOutput is where console logs go
+var logOutput io.Writer = os.Stdout
+
+// setupLogger sets global log level and console logger
+func setupLogger(verbose, vverbose bool) {
+	if vverbose {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if verbose {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	}
+	log.Logger = newLogger(logOutput)
+}
+
+// newLogger creates a console logger writing to out
+func newLogger(out io.Writer) zerolog.Logger {
+	consoleWriter := zerolog.NewConsoleWriter()
+	consoleWriter.Out = out
+	consoleWriter.TimeFormat = time.DateTime
+	return zerolog.New(consoleWriter).With().Timestamp().Logger()
+}
+
+// printChanges prints changes proposed in dry run mode
+func printChanges(src string, changes []FrameChange) {
+	for _, c := range changes {
+		fmt.Printf("%s: %s#%d.%s: %s -> %s\n", src, c.Frame, c.Index, c.Field, c.Old, c.New)
+	}
+}
+
 func parseCmdlineOptions() optionsType {
 	options := optionsType{}
 	flag.StringVar(&options.src, "src", "", "source file name")
 	flag.StringVar(&options.dst, "dst", "", "destination file name. Default: empty (fix in-place)")
-	flag.Var(&options.frames, "frames", "comma-separated list of frames to fix (only for id3v2)")
+	flag.Var(&options.frames, "frames", "comma-separated list of frames to fix (only for id3v2) (default "+
+		strings.Join(supportedV2FrameIDs(), ",")+")")
+	flag.Var(&options.charset, "charset", "source code page of broken tags: auto (detect per tag) or one of "+
+		strings.Join(charsetNames(), ", ")+" (default auto)")
 	flag.BoolVar(&options.listV2Frames, "l", false, "show a full list of supported id3v2 frames")
 	flag.BoolVar(&options.forced, "f", false, "be forceful, do not abort on encoding errors")
+	flag.BoolVar(&options.dryRun, "n", false, "dry run, only print proposed changes, do not write any files")
+	flag.BoolVar(&options.dryRun, "dry-run", false, "same as -n")
+	flag.BoolVar(&options.walk.recursive, "r", false, "walk directories given as arguments recursively")
+	flag.Var((*listValue)(&options.walk.include), "include", "comma-separated list of glob patterns of files to fix (only with -r)")
+	flag.Var((*listValue)(&options.walk.exclude), "exclude", "comma-separated list of glob patterns of files and directories to skip (only with -r)")
+	options.walk.extensions = []string{".mp3"}
+	flag.Var((*listValue)(&options.walk.extensions), "ext", "comma-separated list of file extensions to fix (only with -r)")
+	flag.BoolVar(&options.walk.followSymlinks, "follow-symlinks", false, "follow symlinks while walking directories (only with -r)")
+	flag.IntVar(&options.jobs, "j", 1, "number of files to fix in parallel")
+	flag.StringVar(&options.report, "report", "", "write a json report of all changes to a file, \"-\" for json lines on stdout")
+	flag.BoolVar(&options.backup.disabled, "no-backup", false, "do not back up files fixed in-place")
+	addBackupFlags(flag.CommandLine, &options.backup)
+	flag.BoolVar(&options.noPreserve, "no-preserve", false,
+		"do not carry timestamps, ownership and extended attributes over to fixed files (permissions are always kept)")
+	flag.BoolVar(&options.upgradeV22, "upgrade-v22", false, "upgrade id3v2.2 tags to -target-version, id3v2.4 if it is keep. Default: keep id3v2.2 unless -target-version is set")
+	flag.Var(&options.targetVersion, "target-version", "id3v2 version to write: keep (the original one), 3 or 4. "+
+		"Fixed text is written in UTF-16 for 3 and in UTF-8 for 4. Default: keep")
+	flag.BoolVar(&options.iri, "iri", false, "IRI-normalise links in WXXX and W*** frames: "+
+		"re-encode non-ascii parts, percent-encoded or not, as percent-encoded UTF-8")
+	flag.Var(&options.v1Mode, "v1", "what to do with id3v1 tags of files having id3v2 tags as well: "+
+		"fix (on their own), regenerate (from fixed id3v2 tags) or strip. Default: fix")
+	flag.Var(&options.v1Text, "v1-text", "how id3v1 text is written: translit (into ascii), cp1251 (re-encode into cp1251, "+
+		"which many players show correctly) or v2 (as cp1251, also copy id3v1 only tags into a new id3v2.4 tag in UTF-8). "+
+		"Default: translit")
+	flag.BoolVar(&options.v1Ellipsis, "v1-ellipsis", false, "end id3v1 fields, truncated to 30 bytes "+
+		"(28 for comments with a track number), with an ellipsis. Fields are truncated on word boundaries")
+	flag.BoolVar(&options.v1Overflow, "v1-overflow", false, "copy full values of truncated id3v1 fields "+
+		"into a new id3v2.4 tag in UTF-8 (only for files without id3v2 tags)")
+	flag.BoolVar(&options.v1TrackGenre, "v1-track-genre", false, "also copy id3v1.1 track number and genre "+
+		"into TRCK and TCON of a new id3v2.4 tag (only with -v1-text v2 or -v1-overflow)")
+	flag.BoolVar(&options.v1ResetGenre, "v1-reset-genre", false, "reset id3v1 genres out of the list "+
+		"to none, as players show nothing or garbage for them")
+	options.chains = manglingChains
+	flag.Var(&options.chains, "chains", "comma-separated list of ways broken text could have been mangled, "+
+		"the most plausible cyrillic of them wins: 1byte-latin1 (1-byte code page misread as latin1), "+
+		"utf8-latin1, utf8-cp1251 and their doubles, e.g. utf8-cp1251-utf8-cp1251")
+	options.translit = translitValue(translitSchemes[0])
+	flag.Var(&options.translit, "translit", "transliteration scheme of id3v1 tags: one of "+
+		strings.Join(translitSchemeNames(), ", ")+" (iso9a and iso9b are GOST 7.79 systems A and B, "+
+		"uk and be are ukrainian and belarusian national ones). Letters out of ascii are simplified")
+	flag.StringVar(&options.translitMap, "translit-map", "", "file with letter=replacement lines, "+
+		"overriding transliteration of the given letters")
 	flag.BoolVar(&options.verbose, "v", false, "be verbose")
 	flag.BoolVar(&options.vverbose, "vv", false, "be very verbose (implies -v)")
 	flag.BoolVar(&options.version, "version", false, "show version information")
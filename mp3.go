@@ -1,41 +1,123 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/bogem/id3v2/v2"
 	id3v1 "github.com/frolovo22/tag"
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
+	"golang.org/x/text/encoding/charmap"
 )
 
 type Change struct {
-	Old string
-	New string
+	Old         string  `json:"old"`
+	New         string  `json:"new"`
+	OldEncoding byte    `json:"old_encoding"`         // id3v2 text encoding byte
+	NewEncoding byte    `json:"new_encoding"`         // id3v2 text encoding byte
+	Charset     string  `json:"charset,omitempty"`    // detected source code page
+	Confidence  float64 `json:"confidence,omitempty"` // detection confidence, 0..1
 }
 
-func fixMp3(src, dst string, fixFrames map[string]string, forced bool) error {
-	log.Debug().Msgf("Fixing frames %v in file %s", fixFrames, src)
+// FrameChange is a Change of a single field of a tag frame
+type FrameChange struct {
+	Frame string `json:"frame"` // id3v2 frame id or "ID3v1"
+	Index int    `json:"index"` // index of the frame among frames with the same id
+	Field string `json:"field"`
+	Change
+}
+
+// fixOptions controls how the tags are fixed
+type fixOptions struct {
+	frames  map[string]string // id3v2 frames to fix
+	forced  bool              // do not abort on encoding errors
+	charset *charset          // source code page, nil means autodetect
+	dryRun  bool              // only report changes, do not write anything
+	backup  backupOptions     // backups of in-place fixes
+	// carry timestamps, ownership and extended attributes of the source over to the fixed file
+	preserve      bool
+	upgradeV22    bool   // write id3v2.2 tags back as id3v2.4
+	v1Mode        v1Mode // what to do with id3v1 tags of files having id3v2 tags as well
+	targetVersion byte   // id3v2 version to write, 0 means keep the original one
+	normalizeURLs bool   // IRI-normalise links
+	// mangling chains broken text is tried against, only 1byte-latin1 if empty
+	chains   []manglingChain
+	translit transliterator // transliteration of id3v1 tags
+	v1Text   v1Text         // how id3v1 text is written
+	// end id3v1 fields truncated on a word boundary with an ellipsis
+	v1Ellipsis bool
+	// add full values of truncated id3v1 fields into a new id3v2.4 tag (id3v1 only files)
+	v1Overflow bool
+	// copy id3v1.1 track number and genre into TRCK and TCON of a new id3v2.4 tag (with v1ToV2 or v1Overflow)
+	v1TrackGenre bool
+	// reset id3v1 genres out of the list to none
+	v1ResetGenre bool
+}
+
+// decoding returns the way broken id3v2 text is restored
+func (o fixOptions) decoding() decoding {
+	return decoding{charset: o.charset, chains: o.chains}
+}
+
+// v1Mode is a way to handle id3v1 trailers of files having id3v2 tags as well
+type v1Mode int
+
+const (
+	v1Fix        v1Mode = iota // fix id3v1 tags on their own
+	v1Regenerate               // replace id3v1 tags with transliterated fixed id3v2 values
+	v1Strip                    // remove id3v1 tags
+)
+
+// v1Text is a way id3v1 text is written
+type v1Text int
+
+const (
+	v1Translit v1Text = iota // transliterate into ascii
+	v1Cp1251                 // normalise into cp1251, which many players render correctly
+	v1ToV2                   // normalise into cp1251 and copy into a new id3v2.4 tag in utf8 (id3v1 only files)
+)
+
+const id3v1TagSize = 128
+
+// fixMp3 fixes tags of src, saving result to dst or in-place if dst is empty. Logs go to a logger attached to ctx,
+// cancelling ctx aborts the fix before anything is written. The report is filled even if fixing fails
+func fixMp3(ctx context.Context, src, dst string, opts fixOptions) (report FileReport, err error) {
+	report = FileReport{File: src, Destination: dst, DryRun: opts.dryRun}
+	defer func() {
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+	}()
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msgf("Fixing frames %v in file %s", opts.frames, src)
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
 	// fail early
 	if ok, _ := fileExists(src); !ok {
-		return fmt.Errorf("%s does not exists", src)
+		return report, fmt.Errorf("%s does not exists", src)
 	}
 
 	tmpFile, err := os.CreateTemp("", "tmp*.mp3")
 	if err != nil {
-		return fmt.Errorf("failed creating temp file: %w", err)
+		return report, fmt.Errorf("failed creating temp file: %w", err)
 	}
 	tmpName := tmpFile.Name()
 	defer func() {
 		tmpErr := tmpFile.Close()
 		if tmpErr != nil {
-			log.Error().Msgf("Error closing temp file: %s", tmpErr)
+			logger.Error().Msgf("Error closing temp file: %s", tmpErr)
 		}
 		tmpErr = os.Remove(tmpName)
 		if tmpErr != nil {
-			log.Error().Msgf("Error removing temp file: %s", tmpErr)
+			logger.Error().Msgf("Error removing temp file: %s", tmpErr)
 		}
 	}()
 
@@ -43,135 +125,513 @@ func fixMp3(src, dst string, fixFrames map[string]string, forced bool) error {
 		// fail early
 		dstExists, err := fileExists(dst)
 		if err != nil {
-			return fmt.Errorf("error accessing destination file: %w", err)
+			return report, fmt.Errorf("error accessing destination file: %w", err)
 		}
 		if dstExists {
-			return fmt.Errorf("destination file %s already exists", dst)
+			return report, fmt.Errorf("destination file %s already exists", dst)
 		}
 	}
 
+	// the temp file stays writable, attributes of src are only given to the final file
 	err = copyFileContents(src, tmpName)
 	if err != nil {
-		return fmt.Errorf("failed copying to temp file: %w", err)
+		return report, fmt.Errorf("failed copying to temp file: %w", err)
 	}
 
-	err = fixTags(tmpName, fixFrames, forced)
+	err = fixTags(ctx, tmpName, opts, &report)
 	if err != nil {
-		return fmt.Errorf("failed fixing tags: %w", err)
+		return report, fmt.Errorf("failed fixing tags: %w", err)
+	}
+	if opts.dryRun {
+		logger.Info().Msgf("Dry run, leaving %s untouched", src)
+		return report, nil
+	}
+	// last chance to abort cleanly, nothing is written yet
+	if err := ctx.Err(); err != nil {
+		return report, err
 	}
-	log.Debug().Msgf("Saving fixed file %s", dst)
+	logger.Debug().Msgf("Saving fixed file %s", dst)
 
 	if dst != "" {
-		err = copyFileSafe(tmpName, dst)
+		// permission bits are always kept
+		err = installFile(tmpName, dst, src, opts.preserve)
 		if err != nil {
-			return fmt.Errorf("failed creating output file: %w", err)
+			return report, fmt.Errorf("failed creating output file: %w", err)
 		}
 	} else {
 		// fix in-place
-		backupFile := src + "." + fmt.Sprint(time.Now().Unix()) + ".bak"
-		if ok, _ := fileExists(backupFile); ok {
-			return errors.New("backup already exists")
+		if opts.backup.disabled {
+			logger.Debug().Msgf("Backups disabled, not backing up %s", src)
+		} else {
+			backupFile, err := opts.backup.makeBackup(src, time.Now(), opts.preserve)
+			if err != nil {
+				return report, fmt.Errorf("failed creating a backup: %w", err)
+			}
+			logger.Debug().Msgf("Backed up %s to %s", src, backupFile)
+			report.Backup = backupFile
 		}
-		err = copyFileContents(src, backupFile) // always make backups!
+		err = replaceFile(tmpName, src, src, opts.preserve)
 		if err != nil {
-			return fmt.Errorf("failed creating a backup: %w", err)
+			return report, fmt.Errorf("failed to fix in-place: %w", err)
 		}
-		err = copyFileContents(tmpName, src)
+	}
+
+	return report, nil
+}
+
+func fixTags(ctx context.Context, fileName string, opts fixOptions, report *FileReport) error {
+	// the library id3v2.2 reader is broken, so only the version is detected with it
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return fmt.Errorf("failed to read id3 tags to detect the version: %w", err)
+	}
+	version := id3v1.CheckVersion(fh)
+	fh.Close()
+	report.Version = version.String()
+	switch version {
+	case id3v1.VersionID3v1:
+		truncated, err := fixTagsV1(ctx, fileName, opts, report, nil)
+		switch {
+		case err != nil:
+			return err
+		case opts.v1Text == v1ToV2:
+			return addTagV2FromV1(ctx, fileName, report, truncated, opts.v1TrackGenre)
+		case opts.v1Overflow && len(truncated) > 0:
+			if opts.v1TrackGenre {
+				values, err := readValuesV1(fileName, true)
+				if err != nil {
+					return err
+				}
+				for _, field := range []string{"Track", "Genre"} {
+					if val, ok := values[field]; ok {
+						truncated[field] = val
+					}
+				}
+			}
+			return addTagV2(ctx, fileName, report, truncated)
+		}
+		return nil
+	case id3v1.VersionID3v22:
+		err = fixTagsV22(ctx, fileName, opts, report)
+	case id3v1.VersionID3v23, id3v1.VersionID3v24:
+		err = fixTagsV23(ctx, fileName, opts, report)
+	default:
+		return fmt.Errorf("unsupported id3 version " + version.String())
+	}
+	if err != nil {
+		return err
+	}
+
+	// id3v2 tags are found first, but there still may be an id3v1 trailer
+	hasV1, err := hasTagV1(fileName)
+	if err != nil {
+		return fmt.Errorf("failed to look for id3v1 tags: %w", err)
+	}
+	if !hasV1 {
+		return nil
+	}
+	switch opts.v1Mode {
+	case v1Regenerate:
+		values, err := v1ValuesFromV2(fileName)
 		if err != nil {
-			return fmt.Errorf("failed to fix in-place: %w", err)
+			return fmt.Errorf("failed to read fixed id3v2 tags: %w", err)
 		}
+		// full values are in id3v2 tags already
+		_, err = fixTagsV1(ctx, fileName, opts, report, values)
+		return err
+	case v1Strip:
+		return stripTagV1(ctx, fileName, opts.charset, report)
 	}
+	_, err = fixTagsV1(ctx, fileName, opts, report, nil)
+	return err
+}
 
-	return nil
+// hasTagV1 checks if a file ends with an id3v1 tag
+func hasTagV1(fileName string) (bool, error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return false, err
+	}
+	defer fh.Close()
+	stat, err := fh.Stat()
+	if err != nil {
+		return false, err
+	}
+	if stat.Size() < id3v1TagSize {
+		return false, nil
+	}
+	marker := make([]byte, 3)
+	if _, err := fh.ReadAt(marker, stat.Size()-id3v1TagSize); err != nil {
+		return false, err
+	}
+	return string(marker) == "TAG", nil
 }
 
-func fixTags(fileName string, fixFrames map[string]string, forced bool) error {
-	file, err := id3v1.ReadFile(fileName)
+// v1ValuesFromV2 reads id3v1 fields counterparts from id3v2 tags. Only the fields found are returned
+func v1ValuesFromV2(fileName string) (map[string]string, error) {
+	fh, err := os.Open(fileName)
 	if err != nil {
-		return fmt.Errorf("failed to read id3 tags to detect the version: %w", err)
+		return nil, err
 	}
-	if file.GetVersion() == id3v1.VersionID3v1 {
-		return fixTagsV1(fileName, forced)
-	} else if file.GetVersion() == id3v1.VersionID3v23 || file.GetVersion() == id3v1.VersionID3v24 {
-		return fixTagsV23(fileName, fixFrames, forced)
+	defer fh.Close()
+	values := make(map[string]string)
+	if id3v1.CheckVersion(fh) == id3v1.VersionID3v22 {
+		if _, err := fh.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		tag, err := readV22Tag(fh)
+		if err != nil {
+			return nil, err
+		}
+		fields := map[string]string{"TT2": "Title", "TP1": "Artist", "TAL": "Album", "COM": "Comment"}
+		for _, f := range tag.Frames {
+			field, ok := fields[f.ID]
+			if _, seen := values[field]; !ok || seen {
+				continue
+			}
+			framer, err := f.toFramer()
+			if err != nil {
+				continue
+			}
+			switch v := framer.(type) {
+			case id3v2.TextFrame:
+				values[field] = v.Text
+			case id3v2.CommentFrame:
+				values[field] = v.Text
+			}
+		}
+		return values, nil
 	}
 
-	return fmt.Errorf("unsupported id3 version" + file.GetVersion().String())
+	fh.Close()
+	tag, err := id3v2.Open(fileName, id3v2.Options{Parse: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tag.Close()
+	fields := map[string]string{"TIT2": "Title", "TPE1": "Artist", "TALB": "Album"}
+	for id, field := range fields {
+		if f := tag.GetTextFrame(id); f.Text != "" {
+			values[field] = f.Text
+		}
+	}
+	for _, f := range tag.GetFrames("COMM") {
+		if comment, ok := f.(id3v2.CommentFrame); ok {
+			values["Comment"] = comment.Text
+			break
+		}
+	}
+	return values, nil
 }
 
-func fixTagsV1(fileName string, forced bool) error {
+// stripTagV1 removes an id3v1 trailer, its fields decoded from cs (detected if nil) are reported as changed
+// to empty values
+func stripTagV1(ctx context.Context, fileName string, cs *charset, report *FileReport) error {
+	logger := zerolog.Ctx(ctx)
 	fh, err := os.Open(fileName)
 	if err != nil {
 		return fmt.Errorf("failed opening mp3 file for reading: %w", err)
 	}
-	defer fh.Close()
 	file, err := id3v1.ReadID3v1(fh)
+	fh.Close()
 	if err != nil {
 		return fmt.Errorf("failed to read id3v1 tags: %w", err)
 	}
+	track := ""
+	if trackV1(file) != 0 {
+		track = strconv.Itoa(int(trackV1(file)))
+	}
+	ext := splitTagPlus(file)
+	if ext == nil {
+		ext = &tagPlus{}
+	}
+	for _, f := range []struct{ frame, field, val string }{
+		{"ID3v1", "Title", file.Title + ext.Title}, {"ID3v1", "Artist", file.Artist + ext.Artist},
+		{"ID3v1", "Album", file.Album + ext.Album}, {"ID3v1", "Comment", file.Comment},
+		{"ID3v1", "Track", track}, {"ID3v1", "Genre", genreNameV1(file.Genre)}, {"TAG+", "Genre", ext.Genre},
+	} {
+		if f.val == "" {
+			continue
+		}
+		old, err := decode1byte(f.val, cs)
+		if err != nil {
+			return fmt.Errorf("failed to decode id3v1 tags: %w", err)
+		}
+		report.Changes = append(report.Changes, FrameChange{Frame: f.frame, Field: f.field, Change: Change{Old: old}})
+	}
+	err = os.Truncate(fileName, int64(len(file.Data)))
+	if err != nil {
+		return fmt.Errorf("failed to strip id3v1 tags: %w", err)
+	}
+	logger.Info().Msg("Stripped id3v1 tags")
+
+	return nil
+}
+
+// fixTagsV1 fixes id3v1 tags, transliterating them or normalising into cp1251 depending on opts.v1Text. If values
+// are given, the fields found there are replaced with them instead of fixing. The enhanced TAG+ block is fixed
+// along, if there is one. Full utf8 values of fields, which had to be truncated, are returned
+func fixTagsV1(ctx context.Context, fileName string, opts fixOptions, report *FileReport, values map[string]string) (map[string]string, error) {
+	logger := zerolog.Ctx(ctx)
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening mp3 file for reading: %w", err)
+	}
+	defer fh.Close()
+	file, err := id3v1.ReadID3v1(fh)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read id3v1 tags: %w", err)
+	}
+	if track := trackV1(file); track != 0 {
+		logger.Debug().Msgf("found id3v1.1 track %d", track)
+		report.V1Track = int(track)
+	}
+	totalErrorsCount := 0
+	totalFixedCount := 0
+	if !knownGenreV1(file.Genre) && file.Genre != noGenreV1 {
+		if opts.v1ResetGenre {
+			// players show nothing or garbage for such genres, 255 means no genre
+			logger.Info().Msgf("Fixed tag Genre: %d -> none", file.Genre)
+			totalFixedCount += 1
+			report.Changes = append(report.Changes, FrameChange{Frame: "ID3v1", Field: "Genre",
+				Change: Change{Old: genreNameV1(file.Genre)}})
+			file.Genre = noGenreV1
+		} else {
+			logger.Debug().Msgf("Unknown genre %d, leaving it as is", file.Genre)
+		}
+	}
+	report.V1Genre = genreNameV1(file.Genre)
+	ellipsis := ""
+	if opts.v1Ellipsis {
+		ellipsis = "..."
+		if opts.v1Text != v1Translit {
+			ellipsis = "\x85" // … in cp1251
+		}
+	}
 	type id3v1TagAccessor struct {
+		Frame  string // "ID3v1" or "TAG+"
+		Size   int    // maximum length in bytes
 		Getter func() (string, error)
 		Setter func(string) error
 	}
 	fn := make(map[string]id3v1TagAccessor)
-	fn["Title"] = id3v1TagAccessor{Getter: file.GetTitle, Setter: file.SetTitle}
-	fn["Artist"] = id3v1TagAccessor{Getter: file.GetArtist, Setter: file.SetArtist}
-	fn["Album"] = id3v1TagAccessor{Getter: file.GetAlbum, Setter: file.SetAlbum}
-	fn["Comment"] = id3v1TagAccessor{Getter: file.GetComment, Setter: file.SetComment}
-	totalErrorsCount := 0
-	totalFixedCount := 0
+	fn["Title"] = id3v1TagAccessor{Frame: "ID3v1", Size: 30, Getter: file.GetTitle, Setter: file.SetTitle}
+	fn["Artist"] = id3v1TagAccessor{Frame: "ID3v1", Size: 30, Getter: file.GetArtist, Setter: file.SetArtist}
+	fn["Album"] = id3v1TagAccessor{Frame: "ID3v1", Size: 30, Getter: file.GetAlbum, Setter: file.SetAlbum}
+	// the library setter limits comments to 28 bytes whenever byte 125 is zero, even if there is no track number
+	fn["Comment"] = id3v1TagAccessor{Frame: "ID3v1", Size: commentSizeV1(file), Getter: file.GetComment,
+		Setter: func(s string) error {
+			file.Comment = s
+			return nil
+		}}
+	ext := splitTagPlus(file)
+	if ext != nil {
+		logger.Debug().Msg("found enhanced id3v1 tag TAG+")
+		// the enhanced block continues standard fields, so they are fixed together and split back on saving
+		for field, tail := range map[string]*string{"Title": &ext.Title, "Artist": &ext.Artist, "Album": &ext.Album} {
+			f := fn[field]
+			fn[field] = id3v1TagAccessor{Frame: f.Frame, Size: f.Size + tagPlusFieldSize,
+				Getter: func() (string, error) {
+					head, err := f.Getter()
+					return head + *tail, err
+				},
+				Setter: func(s string) error {
+					head := s[:min(len(s), f.Size)]
+					*tail = s[len(head):]
+					return f.Setter(head)
+				}}
+		}
+		fn["Genre"] = id3v1TagAccessor{Frame: "TAG+", Size: 30,
+			Getter: func() (string, error) {
+				return ext.Genre, nil
+			},
+			Setter: func(s string) error {
+				ext.Genre = s
+				return nil
+			}}
+	}
+	truncated := make(map[string]string)
 	for field, f := range fn {
-		log.Debug().Msgf("found tag %s", field)
+		logger.Debug().Msgf("found tag %s", field)
 		val, err := f.Getter()
 		if err != nil {
-			log.Warn().Err(err).Msgf("Failed to read tag %s", field)
+			logger.Warn().Err(err).Msgf("Failed to read tag %s", field)
+			report.Errors = append(report.Errors, fmt.Sprintf("%s.%s: %s", f.Frame, field, err))
 			totalErrorsCount += 1
 			continue
 		}
-		if val == "" {
+		newVal, regenerate := values[field]
+		if val == "" && !regenerate {
 			continue
 		}
-		fixedVal, err := cp1251ToTranslit(val, 30)
+		// the old value is decoded anyway, as raw bytes are unreadable in reports
+		old, err := decode1byte(val, opts.charset)
+		text := newVal
+		if !regenerate {
+			text = old
+		}
 		if err != nil {
-			log.Warn().Err(err).Msgf("Failed to fix tag %s", field)
+			logger.Warn().Err(err).Msgf("Failed to fix tag %s", field)
+			report.Errors = append(report.Errors, fmt.Sprintf("%s.%s: %s", f.Frame, field, err))
 			totalErrorsCount += 1
 			continue
 		}
+		var full string
+		if opts.v1Text == v1Translit {
+			full = utf8ToTranslit(text, opts.translit)
+		} else {
+			full = utf8ToCp1251(text, opts.translit)
+		}
+		fixedVal := truncateWords(full, f.Size, ellipsis)
+		if fixedVal != full {
+			logger.Debug().Msgf("Truncated tag %s to %d bytes: %s", field, f.Size, text)
+			truncated[field] = text
+		}
 		if fixedVal != val {
-			log.Info().Msgf("Fixed tag %s: %s -> %s", field, val, fixedVal)
+			change := Change{Old: old, New: fixedVal}
+			if opts.v1Text != v1Translit {
+				// cp1251 is shown as it is meant to be read
+				change.New, _ = charmap.Windows1251.NewDecoder().String(fixedVal)
+				change.Charset = "cp1251"
+			}
+			logger.Info().Msgf("Fixed tag %s: %s -> %s", field, old, change.New)
 			totalFixedCount += 1
+			report.Changes = append(report.Changes, FrameChange{Frame: f.Frame, Field: field, Change: change})
 			err = f.Setter(fixedVal)
 			if err != nil {
-				log.Warn().Err(err).Msgf("Failed to set tag %s", field)
+				logger.Warn().Err(err).Msgf("Failed to set tag %s", field)
+				report.Errors = append(report.Errors, fmt.Sprintf("%s.%s: %s", f.Frame, field, err))
 				totalErrorsCount += 1
 			}
 		}
 	}
 	if totalErrorsCount > 0 {
-		if !forced {
-			return fmt.Errorf("got %d error(s) while fixing encoding and aborted", totalErrorsCount)
+		if !opts.forced {
+			return nil, fmt.Errorf("got %d error(s) while fixing encoding and aborted", totalErrorsCount)
 		}
-		log.Error().Msgf("Got %d errors(s) while fixing encoding, proceeding", totalErrorsCount)
+		logger.Error().Msgf("Got %d errors(s) while fixing encoding, proceeding", totalErrorsCount)
 	}
 
 	fh.Close()
 	fh, err = os.OpenFile(fileName, os.O_RDWR, 0)
 	if err != nil {
-		return fmt.Errorf("failed opening mp3 file for writing: %w", err)
+		return nil, fmt.Errorf("failed opening mp3 file for writing: %w", err)
 	}
 	defer fh.Close()
 
-	err = file.Save(fh)
+	data := file.Data
+	if ext != nil {
+		data = append(data, encodeTagPlus(ext)...)
+	}
+	_, err = fh.Write(append(data, encodeTagV1(file)...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save id3v1 tags: %w", err)
+	}
+	logger.Info().Msgf("Fixed %d tag(s)", totalFixedCount)
+
+	return truncated, nil
+}
+
+// addTagV2FromV1 copies id3v1 tags, normalised into cp1251, into a new id3v2.4 tag in utf8. Full values of
+// truncated fields take precedence over id3v1 ones. The track number and genre are copied if trackGenre is set
+func addTagV2FromV1(ctx context.Context, fileName string, report *FileReport, full map[string]string, trackGenre bool) error {
+	values, err := readValuesV1(fileName, trackGenre)
+	if err != nil {
+		return err
+	}
+	for field, val := range full {
+		values[field] = val
+	}
+	return addTagV2(ctx, fileName, report, values)
+}
+
+// readValuesV1 reads id3v1 fields, normalised into cp1251, as utf8 values keyed by field names. TAG+ fields are
+// joined with standard ones. The track number and genre are read only if trackGenre is set
+func readValuesV1(fileName string, trackGenre bool) (map[string]string, error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening mp3 file for reading: %w", err)
+	}
+	file, err := id3v1.ReadID3v1(fh)
+	fh.Close()
 	if err != nil {
-		return fmt.Errorf("failed to save id3v1 tags: %w", err)
+		return nil, fmt.Errorf("failed to read id3v1 tags: %w", err)
+	}
+	ext := splitTagPlus(file)
+	if ext == nil {
+		ext = &tagPlus{}
+	}
+	values := make(map[string]string)
+	decoder := charmap.Windows1251.NewDecoder()
+	for field, val := range map[string]string{
+		"Title": file.Title + ext.Title, "Artist": file.Artist + ext.Artist, "Album": file.Album + ext.Album,
+		"Comment": file.Comment,
+	} {
+		values[field], err = decoder.String(val)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert id3v1 tags: %w", err)
+		}
+	}
+	if file.Year > 0 {
+		values["Year"] = strconv.Itoa(file.Year)
+	}
+	if track := trackV1(file); trackGenre && track != 0 {
+		values["Track"] = strconv.Itoa(int(track))
+	}
+	if trackGenre && ext.Genre != "" {
+		// free-text genre of the enhanced block is more precise
+		values["Genre"], err = decoder.String(ext.Genre)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert id3v1 tags: %w", err)
+		}
+	} else if trackGenre && knownGenreV1(file.Genre) {
+		values["Genre"] = genreNameV1(file.Genre)
 	}
-	log.Info().Msgf("Fixed %d tag(s)", totalFixedCount)
+	return values, nil
+}
+
+// id3v2.4 counterparts of id3v1 fields
+var v1FieldsToV24 = []struct{ field, id string }{
+	{"Title", "TIT2"}, {"Artist", "TPE1"}, {"Album", "TALB"}, {"Year", "TDRC"}, {"Comment", "COMM"},
+	{"Track", "TRCK"}, {"Genre", "TCON"},
+}
+
+// addTagV2 adds id3v1 field values into a new id3v2.4 tag in utf8
+func addTagV2(ctx context.Context, fileName string, report *FileReport, values map[string]string) error {
+	logger := zerolog.Ctx(ctx)
+	tag, err := id3v2.Open(fileName, id3v2.Options{Parse: true})
+	if err != nil {
+		return fmt.Errorf("failed to read mp3 file: %w", err)
+	}
+	defer tag.Close()
+	tag.SetVersion(4)
+
+	for _, f := range v1FieldsToV24 {
+		text := values[f.field]
+		if text == "" {
+			continue
+		}
+		if f.id == "COMM" {
+			tag.AddCommentFrame(id3v2.CommentFrame{Encoding: id3v2.EncodingUTF8, Language: "und", Text: text})
+		} else {
+			tag.AddTextFrame(f.id, id3v2.EncodingUTF8, text)
+		}
+		logger.Info().Msgf("Added frame %s: %s", f.id, text)
+		report.Changes = append(report.Changes, FrameChange{Frame: f.id, Field: "Text",
+			Change: Change{New: text, NewEncoding: id3v2.EncodingUTF8.Key}})
+	}
+	err = tag.Save()
+	if err != nil {
+		return fmt.Errorf("failed saving temp file: %w", err)
+	}
+	logger.Info().Msg("Added id3v2.4 tags")
 
 	return nil
 }
 
-func fixTagsV23(fileName string, fixFrames map[string]string, forced bool) error {
-	if len(fixFrames) == 0 {
+func fixTagsV23(ctx context.Context, fileName string, opts fixOptions, report *FileReport) error {
+	logger := zerolog.Ctx(ctx)
+	if len(opts.frames) == 0 {
 		return errors.New("no frames to fix given")
 	}
 	tag, err := id3v2.Open(fileName, id3v2.Options{Parse: true})
@@ -179,37 +639,66 @@ func fixTagsV23(fileName string, fixFrames map[string]string, forced bool) error
 		return fmt.Errorf("failed to read mp3 file: %w", err)
 	}
 	defer tag.Close()
-	tag.SetVersion(4)
+	version := opts.targetVersion
+	if version == 0 {
+		version = tag.Version()
+	}
+	encoding := textEncoding(version)
 
 	totalErrorsCount := 0
 	totalFixedCount := 0
-	for _, id := range fixFrames {
+	fixChapters := false
+	for _, id := range opts.frames {
+		if id == "CHAP" || id == "CTOC" {
+			fixChapters = true
+		}
+	}
+	// chapters are read even if they are not fixed, as the library would drop their sub-frames on saving
+	err = replaceChapterFrames(tag, fileName)
+	if err != nil && fixChapters {
+		logger.Warn().Err(err).Msg("Failed to read chapters, leaving them as is")
+		report.Errors = append(report.Errors, fmt.Sprintf("CHAP: %s", err))
+		totalErrorsCount += 1
+	} else if err != nil {
+		logger.Warn().Err(err).Msg("Failed to read chapters, only their titles are kept")
+	}
+	for _, id := range opts.frames {
 		actualFrames := tag.GetFrames(id)
-		log.Debug().Msgf("Found %d %s tag(s)", len(actualFrames), id)
+		logger.Debug().Msgf("Found %d %s tag(s)", len(actualFrames), id)
 		fixedFrames := []id3v2.Framer{}
 		fixesCount := 0
 		for i, frame := range actualFrames {
-			fixedFrame, fixes, err := fixV2Frame(frame)
+			frame = parseV2Frame(id, frame)
+			fixedFrame, fixes, err := fixV2Frame(frame, opts.decoding(), encoding, opts.normalizeURLs)
 			if err != nil {
-				log.Warn().Err(err).Msgf("Failed to fix frame %s#%d, leaving it as is", id, i)
+				logger.Warn().Err(err).Msgf("Failed to fix frame %s#%d, leaving it as is", id, i)
+				report.Errors = append(report.Errors, fmt.Sprintf("%s#%d: %s", id, i, err))
 				totalErrorsCount += 1
 				fixedFrames = append(fixedFrames, frame)
 				continue
 			}
 			if fixes == nil {
-				log.Debug().Msgf("Skipping zero difference fix for frame %s#%d", id, i)
+				logger.Debug().Msgf("Skipping zero difference fix for frame %s#%d", id, i)
 				fixedFrames = append(fixedFrames, frame)
 				continue
 			}
-			for field, change := range fixes {
-				log.Info().Msgf("Fixed frame %s#%d.%s: %s -> %s", id, i, field, change.Old, change.New)
+			fields := make([]string, 0, len(fixes))
+			for field := range fixes {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			for _, field := range fields {
+				change := fixes[field]
+				logger.Info().Msgf("Fixed frame %s#%d.%s: %s -> %s (charset %s, confidence %.2f)",
+					id, i, field, change.Old, change.New, change.Charset, change.Confidence)
+				report.Changes = append(report.Changes, FrameChange{Frame: id, Index: i, Field: field, Change: change})
 			}
 			totalFixedCount += 1
 			fixesCount += 1
 			fixedFrames = append(fixedFrames, fixedFrame)
 		}
 		if len(fixedFrames) != len(actualFrames) {
-			log.Fatal().Msgf("Number of fixed frames (%d) does not match actual frames count (%d). "+
+			logger.Fatal().Msgf("Number of fixed frames (%d) does not match actual frames count (%d). "+
 				"This is probably a bug!", len(fixedFrames), len(actualFrames))
 		}
 		if fixesCount > 0 {
@@ -221,63 +710,254 @@ func fixTagsV23(fileName string, fixFrames map[string]string, forced bool) error
 	}
 
 	if totalErrorsCount > 0 {
-		if !forced {
+		if !opts.forced {
 			return fmt.Errorf("got %d error(s) while fixing encoding and aborted", totalErrorsCount)
 		}
-		log.Error().Msgf("Got %d errors(s) while fixing encoding, proceeding", totalErrorsCount)
+		logger.Error().Msgf("Got %d errors(s) while fixing encoding, proceeding", totalErrorsCount)
 	}
 
+	convertTagVersion(tag, version)
 	err = tag.Save()
 	if err != nil {
 		return fmt.Errorf("failed saving temp file: %w", err)
 	}
-	log.Info().Msgf("Fixed %d frame(s)", totalFixedCount)
+	logger.Info().Msgf("Fixed %d frame(s)", totalFixedCount)
 
 	return nil
 }
 
-func fixV2Frame(f id3v2.Framer) (id3v2.Framer, map[string]Change, error) {
+// fixV2Frame fixes text of a frame, the fixed frame is re-encoded with enc. Links are IRI-normalised only if
+// normalizeURLs is set. Only changed fields are reported
+func fixV2Frame(f id3v2.Framer, dec decoding, enc id3v2.Encoding, normalizeURLs bool) (id3v2.Framer, map[string]Change, error) {
 	switch v := f.(type) {
 	case id3v2.UserDefinedTextFrame:
-		val, err := brokenCp1251ToUtf8(v.Value)
+		val, guess, err := brokenToUtf8(v.Value, dec)
 		if err != nil {
 			return nil, nil, err
 		}
 		if val == v.Value {
 			return nil, nil, nil
 		}
+		change := Change{v.Value, val, v.Encoding.Key, enc.Key, guess.Charset.Name, guess.Confidence}
 		v.Value = val
-		v.Encoding = id3v2.EncodingUTF8
-		return v, map[string]Change{"Value": {v.Value, val}}, nil
+		v.Encoding = enc
+		return v, map[string]Change{"Value": change}, nil
 
 	case id3v2.TextFrame:
-		text, err := brokenCp1251ToUtf8(v.Text)
+		text, guess, err := brokenToUtf8(v.Text, dec)
 		if err != nil {
 			return nil, nil, err
 		}
 		if text == v.Text {
 			return nil, nil, nil
 		}
+		change := Change{v.Text, text, v.Encoding.Key, enc.Key, guess.Charset.Name, guess.Confidence}
 		v.Text = text
-		v.Encoding = id3v2.EncodingUTF8
-		return v, map[string]Change{"Text": {v.Text, text}}, nil
+		v.Encoding = enc
+		return v, map[string]Change{"Text": change}, nil
 
 	case id3v2.CommentFrame:
-		text, err := brokenCp1251ToUtf8(v.Text)
+		text, textGuess, err := brokenToUtf8(v.Text, dec)
 		if err != nil {
 			return nil, nil, err
 		}
-		desc, err := brokenCp1251ToUtf8(v.Description)
+		desc, descGuess, err := brokenToUtf8(v.Description, dec)
 		if err != nil {
 			return nil, nil, err
 		}
 		if text == v.Text && desc == v.Description {
 			return nil, nil, nil
 		}
+		changes := make(map[string]Change)
+		if text != v.Text {
+			changes["Text"] = Change{v.Text, text, v.Encoding.Key, enc.Key, textGuess.Charset.Name, textGuess.Confidence}
+		}
+		if desc != v.Description {
+			changes["Description"] = Change{v.Description, desc, v.Encoding.Key, enc.Key, descGuess.Charset.Name, descGuess.Confidence}
+		}
 		v.Text = text
 		v.Description = desc
-		v.Encoding = id3v2.EncodingUTF8
-		return v, map[string]Change{"Text": {v.Text, text}, "Description": {v.Description, desc}}, nil
+		v.Encoding = enc
+		return v, changes, nil
+
+	case id3v2.UnsynchronisedLyricsFrame:
+		lyrics, lyricsGuess, err := brokenToUtf8(v.Lyrics, dec)
+		if err != nil {
+			return nil, nil, err
+		}
+		desc, descGuess, err := brokenToUtf8(v.ContentDescriptor, dec)
+		if err != nil {
+			return nil, nil, err
+		}
+		if lyrics == v.Lyrics && desc == v.ContentDescriptor {
+			return nil, nil, nil
+		}
+		changes := make(map[string]Change)
+		if lyrics != v.Lyrics {
+			changes["Lyrics"] = Change{v.Lyrics, lyrics, v.Encoding.Key, enc.Key, lyricsGuess.Charset.Name, lyricsGuess.Confidence}
+		}
+		if desc != v.ContentDescriptor {
+			changes["ContentDescriptor"] = Change{v.ContentDescriptor, desc, v.Encoding.Key, enc.Key, descGuess.Charset.Name, descGuess.Confidence}
+		}
+		v.Lyrics = lyrics
+		v.ContentDescriptor = desc
+		v.Encoding = enc
+		return v, changes, nil
+
+	case SynchronisedLyricsFrame:
+		// chunks are too short to detect the code page of each one, so it is detected once for the whole frame
+		guess := charsetGuess{Confidence: 1}
+		if dec.charset != nil {
+			guess.Charset = *dec.charset
+		} else {
+			// valid unicode chunks are left as is, so only mojibake ones are taken into account
+			texts := []string{}
+			if classifyText(v.ContentDescriptor) == textMojibake {
+				texts = append(texts, v.ContentDescriptor)
+			}
+			for _, chunk := range v.SyncedTexts {
+				if classifyText(chunk.Text) == textMojibake {
+					texts = append(texts, chunk.Text)
+				}
+			}
+			raw, err := mojibakeBytes(strings.Join(texts, "\n"))
+			if err != nil {
+				return nil, nil, err
+			}
+			guess = detectCharset(raw)
+		}
+		chunkDec := decoding{charset: &guess.Charset, chains: dec.chains}
+		change := func(old, new string, chunkGuess charsetGuess) Change {
+			if chunkGuess.Charset.Name == guess.Charset.Name {
+				chunkGuess = guess
+			}
+			return Change{old, new, v.Encoding.Key, enc.Key, chunkGuess.Charset.Name, chunkGuess.Confidence}
+		}
+		changes := make(map[string]Change)
+		desc, descGuess, err := brokenToUtf8(v.ContentDescriptor, chunkDec)
+		if err != nil {
+			return nil, nil, err
+		}
+		if desc != v.ContentDescriptor {
+			changes["ContentDescriptor"] = change(v.ContentDescriptor, desc, descGuess)
+		}
+		chunks := make([]SyncedText, 0, len(v.SyncedTexts))
+		for i, chunk := range v.SyncedTexts {
+			text, textGuess, err := brokenToUtf8(chunk.Text, chunkDec)
+			if err != nil {
+				return nil, nil, err
+			}
+			if text != chunk.Text {
+				changes[fmt.Sprintf("Text[%d]", i)] = change(chunk.Text, text, textGuess)
+			}
+			chunks = append(chunks, SyncedText{Text: text, Timestamp: chunk.Timestamp})
+		}
+		if len(changes) == 0 {
+			return nil, nil, nil
+		}
+		v.ContentDescriptor = desc
+		v.SyncedTexts = chunks
+		v.Encoding = enc
+		return v, changes, nil
+
+	case id3v2.PictureFrame:
+		// the picture itself is left untouched
+		desc, guess, err := brokenToUtf8(v.Description, dec)
+		if err != nil {
+			return nil, nil, err
+		}
+		if desc == v.Description {
+			return nil, nil, nil
+		}
+		change := Change{v.Description, desc, v.Encoding.Key, enc.Key, guess.Charset.Name, guess.Confidence}
+		v.Description = desc
+		v.Encoding = enc
+		return v, map[string]Change{"Description": change}, nil
+
+	case GeneralEncapsulatedObjectFrame:
+		// the object itself and its mime type are left untouched
+		filename, filenameGuess, err := brokenToUtf8(v.Filename, dec)
+		if err != nil {
+			return nil, nil, err
+		}
+		desc, descGuess, err := brokenToUtf8(v.Description, dec)
+		if err != nil {
+			return nil, nil, err
+		}
+		if filename == v.Filename && desc == v.Description {
+			return nil, nil, nil
+		}
+		changes := make(map[string]Change)
+		if filename != v.Filename {
+			changes["Filename"] = Change{v.Filename, filename, v.Encoding.Key, enc.Key, filenameGuess.Charset.Name, filenameGuess.Confidence}
+		}
+		if desc != v.Description {
+			changes["Description"] = Change{v.Description, desc, v.Encoding.Key, enc.Key, descGuess.Charset.Name, descGuess.Confidence}
+		}
+		v.Filename = filename
+		v.Description = desc
+		v.Encoding = enc
+		return v, changes, nil
+
+	case ChapterFrame:
+		subFrames, changes, err := fixSubFrames(v.SubFrames, dec, enc, normalizeURLs)
+		if err != nil || changes == nil {
+			return nil, nil, err
+		}
+		v.SubFrames = subFrames
+		return v, changes, nil
+
+	case TableOfContentsFrame:
+		subFrames, changes, err := fixSubFrames(v.SubFrames, dec, enc, normalizeURLs)
+		if err != nil || changes == nil {
+			return nil, nil, err
+		}
+		v.SubFrames = subFrames
+		return v, changes, nil
+
+	case UserDefinedURLFrame:
+		desc, descGuess, err := brokenToUtf8(v.Description, dec)
+		if err != nil {
+			return nil, nil, err
+		}
+		url := v.URL
+		urlGuess := charsetGuess{}
+		if normalizeURLs {
+			url, urlGuess, err = normalizeURL(v.URL, dec.charset)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		if desc == v.Description && url == v.URL {
+			return nil, nil, nil
+		}
+		changes := make(map[string]Change)
+		if desc != v.Description {
+			changes["Description"] = Change{v.Description, desc, v.Encoding.Key, enc.Key, descGuess.Charset.Name, descGuess.Confidence}
+		}
+		if url != v.URL {
+			changes["URL"] = Change{v.URL, url, v.Encoding.Key, enc.Key, urlGuess.Charset.Name, urlGuess.Confidence}
+		}
+		v.Description = desc
+		v.URL = url
+		v.Encoding = enc
+		return v, changes, nil
+
+	case URLFrame:
+		if !normalizeURLs {
+			return nil, nil, nil
+		}
+		url, guess, err := normalizeURL(v.URL, dec.charset)
+		if err != nil {
+			return nil, nil, err
+		}
+		if url == v.URL {
+			return nil, nil, nil
+		}
+		// links are always in ISO-8859-1
+		change := Change{v.URL, url, id3v2.EncodingISO.Key, id3v2.EncodingISO.Key, guess.Charset.Name, guess.Confidence}
+		v.URL = url
+		return v, map[string]Change{"URL": change}, nil
 
 	default:
 		return nil, nil, errors.New("failed to detect frame type")
@@ -292,10 +972,23 @@ func supportedV2Frames() map[string]string {
 		if _, ok := seenIds[id]; ok {
 			continue
 		}
-		if id == "COMM" || id[0] == 'T' {
+		if id == "COMM" || id == "APIC" || id == "CHAP" || id[0] == 'T' {
 			supportedFrames[title] = id
 			seenIds[id] = true
 		}
 	}
+	for title, id := range extraFrameIDs {
+		supportedFrames[title] = id
+	}
 	return supportedFrames
 }
+
+// supportedV2FrameIDs returns sorted ids of supported id3v2 frames
+func supportedV2FrameIDs() []string {
+	ids := []string{}
+	for _, id := range supportedV2Frames() {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
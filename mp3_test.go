@@ -1,15 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"os"
 	"path"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/bogem/id3v2/v2"
 	id3v1 "github.com/frolovo22/tag"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/charmap"
 )
 
 func checkV2GoldenFileIntegrity(t *testing.T, goldenFile string) {
@@ -34,7 +41,7 @@ func TestFixMp3(t *testing.T) {
 	tmpFileName := path.Join(os.TempDir(), fmt.Sprintf("%d", rand.Uint64())+".mp3")
 	defer os.Remove(tmpFileName)
 
-	err := fixMp3(goldenFile, tmpFileName, supportedV2Frames(), true)
+	_, err := fixMp3(context.Background(), goldenFile, tmpFileName, fixOptions{frames: supportedV2Frames(), forced: true})
 	assert.NoError(t, err)
 
 	tag, err := id3v2.Open(tmpFileName, id3v2.Options{Parse: true})
@@ -53,7 +60,7 @@ func TestFixMp3Id3V1(t *testing.T) {
 	tmpFileName := path.Join(os.TempDir(), fmt.Sprintf("id3v1-%d", rand.Uint64())+".mp3")
 	defer os.Remove(tmpFileName)
 
-	err := fixMp3(goldenFile, tmpFileName, map[string]string{}, true)
+	_, err := fixMp3(context.Background(), goldenFile, tmpFileName, fixOptions{frames: map[string]string{}, forced: true})
 	assert.NoError(t, err)
 
 	tmpFh, err := os.Open(tmpFileName)
@@ -75,6 +82,255 @@ func TestFixMp3Id3V1(t *testing.T) {
 	assert.Equal(t, "06:55, 44 100 Hz, Stereo, 19", comment)
 }
 
+func TestFixMp3Id3V1_Translit(t *testing.T) {
+	goldenFile := "testdata/troika-id3v1.mp3"
+	checkV1GoldenFileIntegrity(t, goldenFile)
+	dst := filepath.Join(t.TempDir(), "fixed.mp3")
+
+	scheme, err := lookupTranslitScheme("iso9b")
+	assert.NoError(t, err)
+	opts := fixOptions{frames: map[string]string{}, translit: transliterator{scheme: scheme, overrides: map[rune]string{'й': "j"}}}
+	_, err = fixMp3(context.Background(), goldenFile, dst, opts)
+	assert.NoError(t, err)
+
+	fh, err := os.Open(dst)
+	assert.NoError(t, err)
+	defer fh.Close()
+	tag, err := id3v1.ReadID3v1(fh)
+	assert.NoError(t, err)
+	artist, err := tag.GetArtist()
+	assert.NoError(t, err)
+	assert.Equal(t, "A. i  B. Strugaczkie", artist)
+	album, err := tag.GetAlbum()
+	assert.NoError(t, err)
+	assert.Equal(t, "Skazka o Trojke", album)
+}
+
+func TestFixMp3Id3V1_Cp1251(t *testing.T) {
+	goldenFile := "testdata/troika-id3v1.mp3"
+	checkV1GoldenFileIntegrity(t, goldenFile)
+	dst := filepath.Join(t.TempDir(), "fixed.mp3")
+
+	report, err := fixMp3(context.Background(), goldenFile, dst, fixOptions{frames: map[string]string{}, v1Text: v1ToV2,
+		v1TrackGenre: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.V1Track)
+	assert.Equal(t, "Speech", report.V1Genre)
+
+	fh, err := os.Open(dst)
+	assert.NoError(t, err)
+	defer fh.Close()
+	v1, err := id3v1.ReadID3v1(fh)
+	assert.NoError(t, err)
+	album, err := v1.GetAlbum()
+	assert.NoError(t, err)
+	expected, err := charmap.Windows1251.NewEncoder().String("Сказка о Тройке")
+	assert.NoError(t, err)
+	assert.Equal(t, expected, album, "should keep cp1251")
+
+	tag, err := id3v2.Open(dst, id3v2.Options{Parse: true})
+	assert.NoError(t, err)
+	defer tag.Close()
+	assert.Equal(t, byte(4), tag.Version())
+	assert.Equal(t, "Сказка о Тройке", tag.Album())
+	assert.Equal(t, "А. и  Б. Стругацкие", tag.Artist())
+	assert.Equal(t, id3v2.EncodingUTF8, tag.GetTextFrame("TALB").Encoding)
+	assert.Equal(t, "1", tag.GetTextFrame("TRCK").Text)
+	assert.Equal(t, "Speech", tag.Genre())
+	comments := tag.GetFrames("COMM")
+	if assert.Len(t, comments, 1) {
+		assert.Equal(t, "06:55, 44 100 Hz, Stereo, 19", comments[0].(id3v2.CommentFrame).Text)
+	}
+	added := 0
+	for _, c := range report.Changes {
+		if c.Frame != "ID3v1" {
+			added += 1
+		}
+	}
+	assert.GreaterOrEqual(t, added, 4)
+}
+
+func TestFixMp3Id3V1_Truncate(t *testing.T) {
+	goldenFile := "testdata/troika-id3v1.mp3"
+	checkV1GoldenFileIntegrity(t, goldenFile)
+	src := filepath.Join(t.TempDir(), "src.mp3")
+	fh, err := os.Open(goldenFile)
+	assert.NoError(t, err)
+	file, err := id3v1.ReadID3v1(fh)
+	fh.Close()
+	assert.NoError(t, err)
+	file.Title, err = charmap.Windows1251.NewEncoder().String("Жизнь и приключения Щукина")
+	assert.NoError(t, err)
+	file.Comment, err = charmap.Windows1251.NewEncoder().String("Читает Шарыгин, 44100 Hz, 19")
+	assert.NoError(t, err)
+	file.ZeroByte, file.Track = 0, 5
+	assert.NoError(t, os.WriteFile(src, append(file.Data, encodeTagV1(file)...), 0644))
+	dst := filepath.Join(t.TempDir(), "fixed.mp3")
+
+	_, err = fixMp3(context.Background(), src, dst, fixOptions{frames: map[string]string{}, v1Ellipsis: true, v1Overflow: true})
+	assert.NoError(t, err)
+
+	fh, err = os.Open(dst)
+	assert.NoError(t, err)
+	defer fh.Close()
+	v1, err := id3v1.ReadID3v1(fh)
+	assert.NoError(t, err)
+	title, err := v1.GetTitle()
+	assert.NoError(t, err)
+	assert.Equal(t, "Zhizn i prikliucheniia...", title, "should truncate on a word boundary")
+	comment, err := v1.GetComment()
+	assert.NoError(t, err)
+	assert.Equal(t, "Chitaet Sharygin, 44100...", comment, "should fit 28 bytes before the track number")
+	assert.Equal(t, byte(5), v1.Track)
+
+	tag, err := id3v2.Open(dst, id3v2.Options{Parse: true})
+	assert.NoError(t, err)
+	defer tag.Close()
+	assert.Equal(t, "Жизнь и приключения Щукина", tag.Title(), "should keep the full title in id3v2")
+	comments := tag.GetFrames("COMM")
+	if assert.Len(t, comments, 1) {
+		assert.Equal(t, "Читает Шарыгин, 44100 Hz, 19", comments[0].(id3v2.CommentFrame).Text)
+	}
+	assert.Empty(t, tag.Album(), "should not copy fields which fit")
+}
+
+func TestFixMp3Id3V1_Genre(t *testing.T) {
+	goldenFile := "testdata/troika-id3v1.mp3"
+	checkV1GoldenFileIntegrity(t, goldenFile)
+	src := filepath.Join(t.TempDir(), "src.mp3")
+	fh, err := os.Open(goldenFile)
+	assert.NoError(t, err)
+	file, err := id3v1.ReadID3v1(fh)
+	fh.Close()
+	assert.NoError(t, err)
+	file.Genre = 200
+	assert.NoError(t, os.WriteFile(src, append(file.Data, encodeTagV1(file)...), 0644))
+	dst := filepath.Join(t.TempDir(), "fixed.mp3")
+
+	report, err := fixMp3(context.Background(), src, dst, fixOptions{frames: map[string]string{}})
+	assert.NoError(t, err)
+	assert.NotContains(t, report.Changes, FrameChange{Frame: "ID3v1", Field: "Genre", Change: Change{Old: "200"}})
+	assert.Equal(t, "200", report.V1Genre, "should keep unknown genres by default")
+	fh, err = os.Open(dst)
+	assert.NoError(t, err)
+	v1, err := id3v1.ReadID3v1(fh)
+	fh.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, id3v1.Genre(200), v1.Genre)
+
+	dst = filepath.Join(t.TempDir(), "reset.mp3")
+	report, err = fixMp3(context.Background(), src, dst, fixOptions{frames: map[string]string{}, v1ResetGenre: true})
+	assert.NoError(t, err)
+	assert.Contains(t, report.Changes, FrameChange{Frame: "ID3v1", Field: "Genre", Change: Change{Old: "200"}})
+	assert.Equal(t, 1, report.V1Track)
+	assert.Empty(t, report.V1Genre)
+
+	fh, err = os.Open(dst)
+	assert.NoError(t, err)
+	defer fh.Close()
+	v1, err = id3v1.ReadID3v1(fh)
+	assert.NoError(t, err)
+	assert.Equal(t, noGenreV1, v1.Genre, "should reset unknown genres")
+	assert.Equal(t, byte(1), v1.Track, "should keep the track number")
+}
+
+func TestFixMp3Id3V1_GenreOnly(t *testing.T) {
+	goldenFile := "testdata/troika-id3v1.mp3"
+	checkV1GoldenFileIntegrity(t, goldenFile)
+	src := filepath.Join(t.TempDir(), "src.mp3")
+	fh, err := os.Open(goldenFile)
+	assert.NoError(t, err)
+	file, err := id3v1.ReadID3v1(fh)
+	fh.Close()
+	assert.NoError(t, err)
+	file.Title, file.Artist, file.Album, file.Comment, file.Genre = "Glava 1", "Strugatskie", "", "", 200
+	assert.NoError(t, os.WriteFile(src, append(file.Data, encodeTagV1(file)...), 0644))
+	dst := filepath.Join(t.TempDir(), "fixed.mp3")
+
+	logs := bytes.Buffer{}
+	ctx := zerolog.New(&logs).WithContext(context.Background())
+	report, err := fixMp3(ctx, src, dst, fixOptions{frames: map[string]string{}, v1ResetGenre: true})
+	assert.NoError(t, err)
+	assert.Len(t, report.Changes, 1)
+	assert.Contains(t, logs.String(), "Fixed 1 tag(s)", "should count a reset genre as a fix")
+}
+
+func TestFixMp3Id3V1_OverflowTrackGenre(t *testing.T) {
+	goldenFile := "testdata/troika-id3v1.mp3"
+	checkV1GoldenFileIntegrity(t, goldenFile)
+	src := filepath.Join(t.TempDir(), "src.mp3")
+	fh, err := os.Open(goldenFile)
+	assert.NoError(t, err)
+	file, err := id3v1.ReadID3v1(fh)
+	fh.Close()
+	assert.NoError(t, err)
+	file.Title, err = charmap.Windows1251.NewEncoder().String("Жизнь и приключения Щукина")
+	assert.NoError(t, err)
+	file.ZeroByte, file.Track = 0, 5
+	assert.NoError(t, os.WriteFile(src, append(file.Data, encodeTagV1(file)...), 0644))
+	dst := filepath.Join(t.TempDir(), "fixed.mp3")
+
+	opts := fixOptions{frames: map[string]string{}, v1Overflow: true, v1TrackGenre: true}
+	_, err = fixMp3(context.Background(), src, dst, opts)
+	assert.NoError(t, err)
+
+	tag, err := id3v2.Open(dst, id3v2.Options{Parse: true})
+	assert.NoError(t, err)
+	defer tag.Close()
+	assert.Equal(t, "Жизнь и приключения Щукина", tag.Title())
+	assert.Equal(t, "5", tag.GetTextFrame("TRCK").Text, "should copy the track number along with overflowing fields")
+	assert.Equal(t, "Speech", tag.Genre())
+}
+
+func TestFixMp3Id3V1_TagPlus(t *testing.T) {
+	goldenFile := "testdata/troika-id3v1.mp3"
+	checkV1GoldenFileIntegrity(t, goldenFile)
+	src := filepath.Join(t.TempDir(), "src.mp3")
+	fh, err := os.Open(goldenFile)
+	assert.NoError(t, err)
+	file, err := id3v1.ReadID3v1(fh)
+	fh.Close()
+	assert.NoError(t, err)
+	title := "Понедельник начинается в субботу. Сказка для научных сотрудников"
+	koi8r, err := charmap.KOI8R.NewEncoder().String(title)
+	assert.NoError(t, err)
+	file.Title = koi8r[:30]
+	ext := &tagPlus{Title: koi8r[30:], Speed: 2, StartTime: "000:05", EndTime: "006:55"}
+	ext.Genre, err = charmap.KOI8R.NewEncoder().String("Фантастика")
+	assert.NoError(t, err)
+	data := append(append(file.Data, encodeTagPlus(ext)...), encodeTagV1(file)...)
+	assert.NoError(t, os.WriteFile(src, data, 0644))
+	dst := filepath.Join(t.TempDir(), "fixed.mp3")
+
+	report, err := fixMp3(context.Background(), src, dst, fixOptions{frames: map[string]string{}, v1Text: v1ToV2,
+		v1TrackGenre: true})
+	assert.NoError(t, err)
+	assert.Contains(t, report.Changes, FrameChange{Frame: "TAG+", Field: "Genre",
+		Change: Change{Old: "Фантастика", New: "Фантастика", Charset: "cp1251"}})
+
+	fh, err = os.Open(dst)
+	assert.NoError(t, err)
+	defer fh.Close()
+	v1, err := id3v1.ReadID3v1(fh)
+	assert.NoError(t, err)
+	fixedExt := splitTagPlus(v1)
+	if assert.NotNil(t, fixedExt, "should keep TAG+") {
+		cp1251, err := charmap.Windows1251.NewEncoder().String(title)
+		assert.NoError(t, err)
+		assert.Equal(t, cp1251[:30], v1.Title, "should keep the first 30 bytes in the standard tag")
+		assert.Equal(t, cp1251[30:], fixedExt.Title)
+		assert.Equal(t, byte(2), fixedExt.Speed)
+		assert.Equal(t, "000:05", fixedExt.StartTime)
+		assert.Equal(t, "006:55", fixedExt.EndTime)
+	}
+
+	tag, err := id3v2.Open(dst, id3v2.Options{Parse: true})
+	assert.NoError(t, err)
+	defer tag.Close()
+	assert.Equal(t, title, tag.Title(), "should join TAG+ fields")
+	assert.Equal(t, "Фантастика", tag.Genre(), "should prefer the TAG+ genre")
+}
+
 func TestFixMp3_NoFixes(t *testing.T) {
 	goldenFile := "testdata/podenelnik-id3v2.mp3"
 	checkV2GoldenFileIntegrity(t, goldenFile)
@@ -82,7 +338,7 @@ func TestFixMp3_NoFixes(t *testing.T) {
 	tmpFileName := path.Join(os.TempDir(), fmt.Sprintf("%d", rand.Uint64())+".mp3")
 	defer os.Remove(tmpFileName)
 
-	err := fixMp3(goldenFile, tmpFileName, map[string]string{}, true)
+	_, err := fixMp3(context.Background(), goldenFile, tmpFileName, fixOptions{frames: map[string]string{}, forced: true})
 	assert.Error(t, err)
 	assert.Equal(t, "failed fixing tags: no frames to fix given", err.Error())
 }
@@ -101,7 +357,7 @@ func TestFixMp3_DestinationExists(t *testing.T) {
 	file.Close()
 	defer os.Remove(dstFileName)
 
-	err = fixMp3(goldenFile, dstFileName, supportedV2Frames(), true)
+	_, err = fixMp3(context.Background(), goldenFile, dstFileName, fixOptions{frames: supportedV2Frames(), forced: true})
 	assert.Error(t, err)
 	assert.Equal(t, fmt.Sprintf("destination file %s already exists", dstFileName), err.Error())
 }
@@ -119,7 +375,7 @@ func TestFixMp3_InPlaceFix(t *testing.T) {
     backupFileName := tmpGoldenFile + ".bak"
     defer os.Remove(backupFileName)
 
-    err = fixMp3(tmpGoldenFile, "", supportedV2Frames(), true)
+    _, err = fixMp3(context.Background(), tmpGoldenFile, "", fixOptions{frames: supportedV2Frames(), forced: true})
     assert.NoError(t, err)
 
     tag, err := id3v2.Open(tmpGoldenFile, id3v2.Options{Parse: true})
@@ -131,3 +387,400 @@ func TestFixMp3_InPlaceFix(t *testing.T) {
     assert.Equal(t, "2005", tag.Year())
     assert.Equal(t, `"Вокруг света"`, tag.GetTextFrame("TCOP").Text)
 }
+
+func TestFixMp3_ReadOnlySource(t *testing.T) {
+	// id3v1 tags are written into the working file directly
+	src := makeV1V2File(t)
+	dir := filepath.Dir(src)
+	assert.NoError(t, os.Chmod(src, 0444))
+	opts := fixOptions{frames: supportedV2Frames(), forced: true, preserve: true}
+
+	dst := filepath.Join(dir, "fixed.mp3")
+	_, err := fixMp3(context.Background(), src, dst, opts)
+	assert.NoError(t, err, "should fix a read-only source into a destination")
+	stat, err := os.Stat(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0444), stat.Mode().Perm(), "should keep mode of the source")
+
+	report, err := fixMp3(context.Background(), src, "", opts)
+	assert.NoError(t, err, "should fix a read-only source in-place")
+	stat, err = os.Stat(src)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0444), stat.Mode().Perm())
+	tag, err := id3v2.Open(src, id3v2.Options{Parse: true})
+	assert.NoError(t, err)
+	defer tag.Close()
+	assert.Equal(t, "РАО Говорящая книга", tag.GetTextFrame("TENC").Text)
+	hasV1, err := hasTagV1(src)
+	assert.NoError(t, err)
+	assert.True(t, hasV1)
+	stat, err = os.Stat(report.Backup)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0444), stat.Mode().Perm(), "should keep mode of the source in the backup")
+}
+
+func TestFixMp3_PreserveTimes(t *testing.T) {
+	goldenFile := "testdata/podenelnik-id3v2.mp3"
+	checkV2GoldenFileIntegrity(t, goldenFile)
+	dir := t.TempDir()
+	src := filepath.Join(dir, "podenelnik.mp3")
+	assert.NoError(t, copyFileContents(goldenFile, src))
+	mtime := time.Date(2005, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.NoError(t, os.Chtimes(src, mtime, mtime))
+	opts := fixOptions{frames: supportedV2Frames(), forced: true, preserve: true}
+
+	dst := filepath.Join(dir, "fixed.mp3")
+	_, err := fixMp3(context.Background(), src, dst, opts)
+	assert.NoError(t, err)
+	stat, err := os.Stat(dst)
+	assert.NoError(t, err)
+	assert.True(t, mtime.Equal(stat.ModTime()), "should keep modification time of the source in the destination")
+
+	report, err := fixMp3(context.Background(), src, "", opts)
+	assert.NoError(t, err)
+	stat, err = os.Stat(report.Backup)
+	assert.NoError(t, err)
+	assert.True(t, mtime.Equal(stat.ModTime()), "should keep modification time of the source in the backup")
+
+	opts.preserve = false
+	dst = filepath.Join(dir, "fixed-no-preserve.mp3")
+	_, err = fixMp3(context.Background(), src, dst, opts)
+	assert.NoError(t, err)
+	stat, err = os.Stat(dst)
+	assert.NoError(t, err)
+	assert.False(t, mtime.Equal(stat.ModTime()), "should not keep modification time with -no-preserve")
+}
+
+func TestFixMp3_DryRun(t *testing.T) {
+	goldenFile := "testdata/podenelnik-id3v2.mp3"
+	checkV2GoldenFileIntegrity(t, goldenFile)
+
+	tmpGoldenFile := path.Join(os.TempDir(), fmt.Sprintf("%d", rand.Uint64())+".mp3")
+	err := copyFileContents(goldenFile, tmpGoldenFile)
+	assert.NoError(t, err)
+	defer os.Remove(tmpGoldenFile)
+
+	_, err = fixMp3(context.Background(), tmpGoldenFile, "", fixOptions{frames: supportedV2Frames(), forced: true, dryRun: true})
+	assert.NoError(t, err)
+
+	checkV2GoldenFileIntegrity(t, tmpGoldenFile)
+	backups, err := filepath.Glob(tmpGoldenFile + ".*.bak")
+	assert.NoError(t, err)
+	assert.Empty(t, backups, "should not create backups")
+}
+
+func TestFixMp3_Report(t *testing.T) {
+	goldenFile := "testdata/podenelnik-id3v2.mp3"
+	checkV2GoldenFileIntegrity(t, goldenFile)
+
+	tmpGoldenFile := filepath.Join(t.TempDir(), "podenelnik.mp3")
+	err := copyFileContents(goldenFile, tmpGoldenFile)
+	assert.NoError(t, err)
+
+	report, err := fixMp3(context.Background(), tmpGoldenFile, "", fixOptions{frames: supportedV2Frames(), forced: true})
+	assert.NoError(t, err)
+	assert.Equal(t, tmpGoldenFile, report.File)
+	assert.Equal(t, "id3v2.4", report.Version)
+	assert.FileExists(t, report.Backup)
+	assert.Len(t, report.Changes, 4)
+	assert.Empty(t, report.Errors, "should skip frames holding valid utf8")
+	for _, c := range report.Changes {
+		assert.Equal(t, id3v2.EncodingUTF8.Key, c.NewEncoding)
+	}
+}
+
+func TestFixMp3_ReportV1(t *testing.T) {
+	goldenFile := "testdata/troika-id3v1.mp3"
+	checkV1GoldenFileIntegrity(t, goldenFile)
+	dst := filepath.Join(t.TempDir(), "fixed.mp3")
+
+	report, err := fixMp3(context.Background(), goldenFile, dst, fixOptions{frames: map[string]string{}})
+	assert.NoError(t, err)
+	data, err := json.Marshal(report)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "\\ufffd", "should not write raw bytes")
+	found := false
+	for _, c := range report.Changes {
+		if c.Frame == "ID3v1" && c.Field == "Album" {
+			found = true
+			assert.Equal(t, "Сказка о Тройке", c.Old, "should decode the old value")
+			assert.Equal(t, "Skazka o Troike", c.New)
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestFixMp3_NoBackup(t *testing.T) {
+	goldenFile := "testdata/podenelnik-id3v2.mp3"
+	checkV2GoldenFileIntegrity(t, goldenFile)
+
+	dir := t.TempDir()
+	tmpGoldenFile := filepath.Join(dir, "podenelnik.mp3")
+	err := copyFileContents(goldenFile, tmpGoldenFile)
+	assert.NoError(t, err)
+
+	opts := fixOptions{frames: supportedV2Frames(), forced: true, backup: backupOptions{disabled: true}}
+	report, err := fixMp3(context.Background(), tmpGoldenFile, "", opts)
+	assert.NoError(t, err)
+	assert.Empty(t, report.Backup)
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "should not create backups")
+}
+
+func makeV1V2File(t *testing.T) string {
+	v2, err := os.ReadFile("testdata/podenelnik-id3v2.mp3")
+	assert.NoError(t, err)
+	v1, err := os.ReadFile("testdata/troika-id3v1.mp3")
+	assert.NoError(t, err)
+	name := filepath.Join(t.TempDir(), "both.mp3")
+	assert.NoError(t, os.WriteFile(name, append(v2, v1[len(v1)-id3v1TagSize:]...), 0644))
+	return name
+}
+
+func TestFixMp3_BothV1AndV2(t *testing.T) {
+	for _, tc := range []struct {
+		mode          v1Mode
+		title, artist string
+	}{
+		{v1Fix, "Gl. 1-1", "A. i  B. Strugatskie"},
+		{v1Regenerate, "Istoriia 1. Gl.1-1", "A. i B. Strugatskie"},
+	} {
+		src := makeV1V2File(t)
+		dst := filepath.Join(t.TempDir(), "fixed.mp3")
+		_, err := fixMp3(context.Background(), src, dst, fixOptions{frames: supportedV2Frames(), forced: true, v1Mode: tc.mode})
+		assert.NoError(t, err)
+
+		tag, err := id3v2.Open(dst, id3v2.Options{Parse: true})
+		assert.NoError(t, err)
+		assert.Equal(t, "Понедельник начинается в субботу", tag.Album())
+		tag.Close()
+
+		fh, err := os.Open(dst)
+		assert.NoError(t, err)
+		v1, err := id3v1.ReadID3v1(fh)
+		fh.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, tc.title, v1.Title)
+		assert.Equal(t, tc.artist, v1.Artist)
+	}
+}
+
+func TestFixMp3_StripV1(t *testing.T) {
+	src := makeV1V2File(t)
+	dst := filepath.Join(t.TempDir(), "fixed.mp3")
+	report, err := fixMp3(context.Background(), src, dst, fixOptions{frames: supportedV2Frames(), forced: true, v1Mode: v1Strip})
+	assert.NoError(t, err)
+
+	hasV1, err := hasTagV1(dst)
+	assert.NoError(t, err)
+	assert.False(t, hasV1)
+	stripped := 0
+	for _, c := range report.Changes {
+		if c.Frame == "ID3v1" {
+			assert.Empty(t, c.New)
+			stripped += 1
+		}
+	}
+	assert.Equal(t, 6, stripped, "should report track and genre as well")
+
+	tag, err := id3v2.Open(dst, id3v2.Options{Parse: true})
+	assert.NoError(t, err)
+	defer tag.Close()
+	assert.Equal(t, "Понедельник начинается в субботу", tag.Album())
+}
+
+func TestFixV2Frame(t *testing.T) {
+	cp1251, err := lookupCharset("cp1251")
+	assert.NoError(t, err)
+	broken := func(s string) string {
+		b, err := cp1251.Encoding.NewEncoder().String(s)
+		assert.NoError(t, err)
+		return latin1(b)
+	}
+	change := func(old, new string, newEncoding id3v2.Encoding) Change {
+		return Change{Old: old, New: new, OldEncoding: id3v2.EncodingISO.Key, NewEncoding: newEncoding.Key,
+			Charset: "cp1251", Confidence: 1}
+	}
+
+	for _, tc := range []struct {
+		name          string
+		frame         id3v2.Framer
+		enc           id3v2.Encoding
+		normalizeURLs bool
+		fixed         id3v2.Framer
+		changes       map[string]Change
+	}{
+		{
+			name:    "text",
+			frame:   id3v2.TextFrame{Encoding: id3v2.EncodingISO, Text: broken("Понедельник")},
+			enc:     id3v2.EncodingUTF8,
+			fixed:   id3v2.TextFrame{Encoding: id3v2.EncodingUTF8, Text: "Понедельник"},
+			changes: map[string]Change{"Text": change(broken("Понедельник"), "Понедельник", id3v2.EncodingUTF8)},
+		},
+		{
+			name:    "text to utf16",
+			frame:   id3v2.TextFrame{Encoding: id3v2.EncodingISO, Text: broken("Понедельник")},
+			enc:     id3v2.EncodingUTF16,
+			fixed:   id3v2.TextFrame{Encoding: id3v2.EncodingUTF16, Text: "Понедельник"},
+			changes: map[string]Change{"Text": change(broken("Понедельник"), "Понедельник", id3v2.EncodingUTF16)},
+		},
+		{
+			name:    "user defined text",
+			frame:   id3v2.UserDefinedTextFrame{Encoding: id3v2.EncodingISO, Description: "Reader", Value: broken("Шарыгин")},
+			enc:     id3v2.EncodingUTF8,
+			fixed:   id3v2.UserDefinedTextFrame{Encoding: id3v2.EncodingUTF8, Description: "Reader", Value: "Шарыгин"},
+			changes: map[string]Change{"Value": change(broken("Шарыгин"), "Шарыгин", id3v2.EncodingUTF8)},
+		},
+		{
+			name: "comment",
+			frame: id3v2.CommentFrame{Encoding: id3v2.EncodingISO, Language: "rus",
+				Description: broken("Чтец"), Text: broken("Шарыгин")},
+			enc: id3v2.EncodingUTF8,
+			fixed: id3v2.CommentFrame{Encoding: id3v2.EncodingUTF8, Language: "rus",
+				Description: "Чтец", Text: "Шарыгин"},
+			changes: map[string]Change{
+				"Description": change(broken("Чтец"), "Чтец", id3v2.EncodingUTF8),
+				"Text":        change(broken("Шарыгин"), "Шарыгин", id3v2.EncodingUTF8),
+			},
+		},
+		{
+			name:    "comment with unchanged description",
+			frame:   id3v2.CommentFrame{Encoding: id3v2.EncodingISO, Language: "rus", Text: broken("Шарыгин")},
+			enc:     id3v2.EncodingUTF8,
+			fixed:   id3v2.CommentFrame{Encoding: id3v2.EncodingUTF8, Language: "rus", Text: "Шарыгин"},
+			changes: map[string]Change{"Text": change(broken("Шарыгин"), "Шарыгин", id3v2.EncodingUTF8)},
+		},
+		{
+			name: "unsynchronised lyrics",
+			frame: id3v2.UnsynchronisedLyricsFrame{Encoding: id3v2.EncodingISO, Language: "rus",
+				ContentDescriptor: broken("Глава"), Lyrics: broken("Понедельник\nначинается в субботу")},
+			enc: id3v2.EncodingUTF8,
+			fixed: id3v2.UnsynchronisedLyricsFrame{Encoding: id3v2.EncodingUTF8, Language: "rus",
+				ContentDescriptor: "Глава", Lyrics: "Понедельник\nначинается в субботу"},
+			changes: map[string]Change{
+				"ContentDescriptor": change(broken("Глава"), "Глава", id3v2.EncodingUTF8),
+				"Lyrics": change(broken("Понедельник\nначинается в субботу"), "Понедельник\nначинается в субботу",
+					id3v2.EncodingUTF8),
+			},
+		},
+		{
+			name: "synchronised lyrics",
+			frame: SynchronisedLyricsFrame{Encoding: id3v2.EncodingISO, Language: "rus", TimestampFormat: 2, ContentType: 1,
+				SyncedTexts: []SyncedText{{broken("Понедельник"), 0}, {"...", 500}, {broken("суббота"), 1000}}},
+			enc: id3v2.EncodingUTF16,
+			fixed: SynchronisedLyricsFrame{Encoding: id3v2.EncodingUTF16, Language: "rus", TimestampFormat: 2, ContentType: 1,
+				SyncedTexts: []SyncedText{{"Понедельник", 0}, {"...", 500}, {"суббота", 1000}}},
+			changes: map[string]Change{
+				"Text[0]": change(broken("Понедельник"), "Понедельник", id3v2.EncodingUTF16),
+				"Text[2]": change(broken("суббота"), "суббота", id3v2.EncodingUTF16),
+			},
+		},
+		{
+			name: "picture",
+			frame: id3v2.PictureFrame{Encoding: id3v2.EncodingISO, MimeType: "image/jpeg", PictureType: id3v2.PTFrontCover,
+				Description: broken("Обложка"), Picture: []byte{0xff, 0xd8, 0xff}},
+			enc: id3v2.EncodingUTF8,
+			fixed: id3v2.PictureFrame{Encoding: id3v2.EncodingUTF8, MimeType: "image/jpeg", PictureType: id3v2.PTFrontCover,
+				Description: "Обложка", Picture: []byte{0xff, 0xd8, 0xff}},
+			changes: map[string]Change{"Description": change(broken("Обложка"), "Обложка", id3v2.EncodingUTF8)},
+		},
+		{
+			name: "object",
+			frame: GeneralEncapsulatedObjectFrame{Encoding: id3v2.EncodingISO, MimeType: "text/plain",
+				Filename: broken("глава.txt"), Description: "Text", Object: []byte{0xc3, 0xeb}},
+			enc: id3v2.EncodingUTF8,
+			fixed: GeneralEncapsulatedObjectFrame{Encoding: id3v2.EncodingUTF8, MimeType: "text/plain",
+				Filename: "глава.txt", Description: "Text", Object: []byte{0xc3, 0xeb}},
+			changes: map[string]Change{"Filename": change(broken("глава.txt"), "глава.txt", id3v2.EncodingUTF8)},
+		},
+		{
+			name:    "user defined url",
+			frame:   UserDefinedURLFrame{Encoding: id3v2.EncodingISO, Description: broken("Сайт"), URL: "http://a.ru/%D1%E0%E9%F2"},
+			enc:     id3v2.EncodingUTF8,
+			fixed:   UserDefinedURLFrame{Encoding: id3v2.EncodingUTF8, Description: "Сайт", URL: "http://a.ru/%D1%E0%E9%F2"},
+			changes: map[string]Change{"Description": change(broken("Сайт"), "Сайт", id3v2.EncodingUTF8)},
+		},
+		{
+			name:          "user defined url normalised",
+			frame:         UserDefinedURLFrame{Encoding: id3v2.EncodingISO, Description: "Site", URL: "http://a.ru/%D1%E0%E9%F2"},
+			enc:           id3v2.EncodingUTF8,
+			normalizeURLs: true,
+			fixed: UserDefinedURLFrame{Encoding: id3v2.EncodingUTF8, Description: "Site",
+				URL: "http://a.ru/%D0%A1%D0%B0%D0%B9%D1%82"},
+			changes: map[string]Change{"URL": change("http://a.ru/%D1%E0%E9%F2", "http://a.ru/%D0%A1%D0%B0%D0%B9%D1%82", id3v2.EncodingUTF8)},
+		},
+		{
+			name:  "url is not normalised by default",
+			frame: URLFrame{URL: "http://a.ru/" + broken("Сайт")},
+			enc:   id3v2.EncodingUTF8,
+		},
+		{
+			name:          "mangled url",
+			frame:         URLFrame{URL: "http://a.ru/" + broken("Сайт") + "?q=%2F"},
+			enc:           id3v2.EncodingUTF8,
+			normalizeURLs: true,
+			fixed:         URLFrame{URL: "http://a.ru/%D0%A1%D0%B0%D0%B9%D1%82?q=%2F"},
+			changes: map[string]Change{"URL": change("http://a.ru/"+broken("Сайт")+"?q=%2F",
+				"http://a.ru/%D0%A1%D0%B0%D0%B9%D1%82?q=%2F", id3v2.EncodingISO)},
+		},
+		{
+			name:          "utf8 url",
+			frame:         URLFrame{URL: "http://a.ru/%d0%a1"},
+			enc:           id3v2.EncodingUTF8,
+			normalizeURLs: true,
+		},
+		{
+			name:  "ascii",
+			frame: id3v2.TextFrame{Encoding: id3v2.EncodingISO, Text: "2005"},
+			enc:   id3v2.EncodingUTF8,
+		},
+		{
+			name:  "valid unicode",
+			frame: id3v2.TextFrame{Encoding: id3v2.EncodingUTF8, Text: "Понедельник"},
+			enc:   id3v2.EncodingUTF8,
+		},
+		{
+			name: "valid unicode description",
+			frame: id3v2.CommentFrame{Encoding: id3v2.EncodingUTF16, Language: "rus",
+				Description: "Чтец", Text: broken("Шарыгин")},
+			enc: id3v2.EncodingUTF8,
+			fixed: id3v2.CommentFrame{Encoding: id3v2.EncodingUTF8, Language: "rus",
+				Description: "Чтец", Text: "Шарыгин"},
+			changes: map[string]Change{"Text": {Old: broken("Шарыгин"), New: "Шарыгин", OldEncoding: id3v2.EncodingUTF16.Key,
+				NewEncoding: id3v2.EncodingUTF8.Key, Charset: "cp1251", Confidence: 1}},
+		},
+		{
+			name: "synchronised lyrics with valid unicode",
+			frame: SynchronisedLyricsFrame{Encoding: id3v2.EncodingISO, Language: "rus", TimestampFormat: 2, ContentType: 1,
+				SyncedTexts: []SyncedText{{"Понедельник", 0}, {broken("суббота"), 1000}}},
+			enc: id3v2.EncodingUTF8,
+			fixed: SynchronisedLyricsFrame{Encoding: id3v2.EncodingUTF8, Language: "rus", TimestampFormat: 2, ContentType: 1,
+				SyncedTexts: []SyncedText{{"Понедельник", 0}, {"суббота", 1000}}},
+			changes: map[string]Change{"Text[1]": change(broken("суббота"), "суббота", id3v2.EncodingUTF8)},
+		},
+		{
+			name:          "unicode url",
+			frame:         URLFrame{URL: "http://a.ru/Сайт"},
+			enc:           id3v2.EncodingUTF8,
+			normalizeURLs: true,
+			fixed:         URLFrame{URL: "http://a.ru/%D0%A1%D0%B0%D0%B9%D1%82"},
+			changes: map[string]Change{"URL": {Old: "http://a.ru/Сайт", New: "http://a.ru/%D0%A1%D0%B0%D0%B9%D1%82",
+				OldEncoding: id3v2.EncodingISO.Key, NewEncoding: id3v2.EncodingISO.Key, Charset: "utf8", Confidence: 1}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fixed, changes, err := fixV2Frame(tc.frame, decoding{charset: &cp1251}, tc.enc, tc.normalizeURLs)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.fixed, fixed)
+			assert.Equal(t, tc.changes, changes)
+		})
+	}
+
+	_, _, err = fixV2Frame(id3v2.TextFrame{Encoding: id3v2.EncodingUTF8, Text: "\xd1\xe0\xe9\xf2"}, decoding{charset: &cp1251}, id3v2.EncodingUTF8, false)
+	assert.ErrorIs(t, err, errUndecodable, "should fail on invalid utf8")
+
+	_, _, err = fixV2Frame(id3v2.PopularimeterFrame{}, decoding{charset: &cp1251}, id3v2.EncodingUTF8, false)
+	assert.Error(t, err, "should fail on unsupported frames")
+}
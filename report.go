@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// FileReport is a machine-readable record of everything done to a single file
+type FileReport struct {
+	File        string        `json:"file"`
+	Destination string        `json:"destination,omitempty"` // empty for in-place fixes
+	Version     string        `json:"version,omitempty"`     // detected tag version
+	V1Track     int           `json:"v1_track,omitempty"`    // id3v1.1 track number
+	V1Genre     string        `json:"v1_genre,omitempty"`    // id3v1 genre name
+	DryRun      bool          `json:"dry_run,omitempty"`
+	Changes     []FrameChange `json:"changes"`
+	Errors      []string      `json:"errors,omitempty"`
+	Backup      string        `json:"backup,omitempty"`
+}
+
+// reportWriter writes file reports either as a single json array to a file or as json lines to stdout
+type reportWriter struct {
+	out     io.WriteCloser
+	lines   bool
+	reports []FileReport
+}
+
+// newReportWriter creates a report writer for a file name, "-" stands for json lines on stdout
+func newReportWriter(name string) (*reportWriter, error) {
+	if name == "-" {
+		return &reportWriter{out: os.Stdout, lines: true}, nil
+	}
+	out, err := os.Create(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating report file: %w", err)
+	}
+	return &reportWriter{out: out}, nil
+}
+
+// Add records a report of a single file, json lines are written immediately
+func (w *reportWriter) Add(report FileReport) error {
+	if report.Changes == nil {
+		report.Changes = []FrameChange{}
+	}
+	if !w.lines {
+		w.reports = append(w.reports, report)
+		return nil
+	}
+	return json.NewEncoder(w.out).Encode(report)
+}
+
+// Close writes collected reports and closes the report file
+func (w *reportWriter) Close() error {
+	if w.lines {
+		// never close stdout
+		return nil
+	}
+	if w.reports == nil {
+		w.reports = []FileReport{}
+	}
+	encoder := json.NewEncoder(w.out)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(w.reports)
+	closeErr := w.out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
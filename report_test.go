@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportWriter(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "report.json")
+	w, err := newReportWriter(name)
+	assert.NoError(t, err)
+
+	assert.NoError(t, w.Add(FileReport{
+		File:    "a.mp3",
+		Version: "id3v2.4",
+		Changes: []FrameChange{{Frame: "TIT2", Field: "Text", Change: Change{Old: "Ãëàâà", New: "Глава", NewEncoding: 3}}},
+		Backup:  "a.mp3.1.bak",
+	}))
+	assert.NoError(t, w.Add(FileReport{File: "b.mp3", Errors: []string{"b.mp3 does not exists"}}))
+	assert.NoError(t, w.Close())
+
+	data, err := os.ReadFile(name)
+	assert.NoError(t, err)
+	actual := []map[string]any{}
+	assert.NoError(t, json.Unmarshal(data, &actual))
+	assert.Len(t, actual, 2)
+	assert.Equal(t, "id3v2.4", actual[0]["version"])
+	assert.Equal(t, "a.mp3.1.bak", actual[0]["backup"])
+	assert.Equal(t, []any{map[string]any{
+		"frame": "TIT2", "index": 0.0, "field": "Text", "old": "Ãëàâà", "new": "Глава", "old_encoding": 0.0, "new_encoding": 3.0,
+	}}, actual[0]["changes"])
+	assert.Equal(t, []any{}, actual[1]["changes"], "should not write null changes")
+	assert.Equal(t, []any{"b.mp3 does not exists"}, actual[1]["errors"])
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// restoreFile restores src from its latest backup or from the one made at a given unix timestamp (if ts > 0),
+// the backup is removed afterwards if asked. A deleted src is restored too
+func restoreFile(src string, ts int64, remove bool, opts backupOptions) (backupFile, error) {
+	backups, err := opts.find(src)
+	if err != nil {
+		return backupFile{}, fmt.Errorf("failed looking for backups: %w", err)
+	}
+	if len(backups) == 0 {
+		return backupFile{}, fmt.Errorf("no backups of %s found", src)
+	}
+	backup := backups[len(backups)-1]
+	if ts > 0 {
+		found := false
+		for _, b := range backups {
+			if b.Time.Unix() == ts {
+				backup = b
+				found = true
+				break
+			}
+		}
+		if !found {
+			return backupFile{}, fmt.Errorf("no backup of %s made at %d found", src, ts)
+		}
+	}
+
+	if err := verifyBackup(backup); err != nil {
+		return backup, err
+	}
+	if err := replaceFile(backup.Path, src, backup.Path, true); err != nil {
+		return backup, fmt.Errorf("failed restoring %s: %w", src, err)
+	}
+	log.Info().Msgf("Restored %s from %s", src, backup.Path)
+
+	if remove {
+		if err := removeBackup(backup.Path); err != nil {
+			return backup, fmt.Errorf("failed removing backup: %w", err)
+		}
+		log.Debug().Msgf("Removed backup %s", backup.Path)
+	}
+	return backup, nil
+}
+
+// verifyBackup checks that a backup is a non-empty regular file matching its checksum
+func verifyBackup(backup backupFile) error {
+	stat, err := os.Stat(backup.Path)
+	if err != nil {
+		return fmt.Errorf("failed accessing backup: %w", err)
+	}
+	if !stat.Mode().IsRegular() {
+		return fmt.Errorf("backup %s is not a regular file", backup.Path)
+	}
+	if stat.Size() == 0 {
+		return fmt.Errorf("backup %s is empty", backup.Path)
+	}
+	return verifyChecksum(backup.Path)
+}
+
+// pruneBackups removes backups of sources made before a given time. In recursive mode, all backups
+// found in given directories are pruned
+func pruneBackups(paths []string, before time.Time, walk walkOptions, dryRun bool, opts backupOptions) (int, error) {
+	backups := []backupFile{}
+	walk.extensions = nil
+	names, err := collectSources(paths, walk)
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range names {
+		if b, ok := opts.parse(name); ok {
+			backups = append(backups, b)
+			continue
+		}
+		found, err := opts.find(name)
+		if err != nil {
+			return 0, err
+		}
+		backups = append(backups, found...)
+	}
+
+	pruned := 0
+	seen := make(map[string]bool)
+	for _, b := range backups {
+		// a backup could be both walked over and found by its source
+		if seen[b.Path] || !b.Time.Before(before) {
+			continue
+		}
+		seen[b.Path] = true
+		if dryRun {
+			fmt.Println(b.Path)
+			pruned += 1
+			continue
+		}
+		if err := removeBackup(b.Path); err != nil {
+			return pruned, fmt.Errorf("failed removing backup: %w", err)
+		}
+		log.Debug().Msgf("Removed backup %s", b.Path)
+		pruned += 1
+	}
+	return pruned, nil
+}
+
+// addBackupFlags adds cmdline options of the backup location and naming to a flag set
+func addBackupFlags(flags *flag.FlagSet, backup *backupOptions) {
+	flags.StringVar(&backup.dir, "backup-dir", "", "directory for backups. Default: empty (next to the source)")
+	flags.BoolVar(&backup.mirror, "backup-mirror", false, "mirror source directory tree inside the backup directory")
+	flags.StringVar(&backup.template, "backup-name", defaultBackupTemplate,
+		"backup file name template ending with .bak, {name} is a source file name, {ts} is a unix timestamp")
+}
+
+// parseAge parses a duration, additionally accepting days, e.g. "30d"
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid age %s", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// restoreCmd runs the restore subcommand and returns an exit code
+func restoreCmd(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	ts := flags.Int64("t", 0, "unix timestamp of the backup to restore. Default: the latest one")
+	remove := flags.Bool("delete", false, "delete the backup after restoring")
+	forced := flags.Bool("f", false, "be forceful, do not abort on errors")
+	walk := walkOptions{extensions: []string{".mp3"}}
+	flags.BoolVar(&walk.recursive, "r", false, "walk directories given as arguments recursively")
+	backup := backupOptions{}
+	addBackupFlags(flags, &backup)
+	verbose := flags.Bool("v", false, "be verbose")
+	flags.Usage = func() {
+		fmt.Printf("Usage:\n")
+		fmt.Printf("       %s restore [-t <timestamp>] [-delete] <file.mp3 | directory> ...\n", filepath.Base(os.Args[0]))
+		fmt.Println("Arguments:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	setupLogger(*verbose, false)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 1
+	}
+	if err := backup.validate(); err != nil {
+		log.Error().Err(err).Msg("")
+		return 1
+	}
+
+	sources, err := collectSources(flags.Args(), walk)
+	if err != nil {
+		log.Error().Err(err).Msg("")
+		return 1
+	}
+	restoredCnt, errCnt := 0, 0
+	for _, src := range sources {
+		_, err := restoreFile(src, *ts, *remove, backup)
+		if err != nil {
+			log.Error().Err(err).Msg("")
+			errCnt += 1
+			if !*forced {
+				log.Error().Msg("Aborting...")
+				break
+			}
+			continue
+		}
+		restoredCnt += 1
+	}
+	log.Info().Msgf("Restored %d/%d files", restoredCnt, len(sources))
+	if errCnt > 0 {
+		return 1
+	}
+	return 0
+}
+
+// backupsCmd runs the backups subcommand and returns an exit code
+func backupsCmd(args []string) int {
+	usage := func() {
+		fmt.Printf("Usage:\n")
+		fmt.Printf("       %s backups prune -older-than <age> <file.mp3 | directory> ...\n", filepath.Base(os.Args[0]))
+	}
+	if len(args) == 0 || args[0] != "prune" {
+		usage()
+		return 1
+	}
+
+	flags := flag.NewFlagSet("backups prune", flag.ExitOnError)
+	olderThan := flags.String("older-than", "", "remove backups older than a given age, e.g. 12h or 30d")
+	dryRun := flags.Bool("n", false, "dry run, only print backups to remove")
+	walk := walkOptions{}
+	flags.BoolVar(&walk.recursive, "r", false, "walk directories given as arguments recursively")
+	backup := backupOptions{}
+	addBackupFlags(flags, &backup)
+	verbose := flags.Bool("v", false, "be verbose")
+	flags.Usage = func() {
+		usage()
+		fmt.Println("Arguments:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args[1:])
+	setupLogger(*verbose, false)
+	if flags.NArg() == 0 || *olderThan == "" {
+		flags.Usage()
+		return 1
+	}
+	if err := backup.validate(); err != nil {
+		log.Error().Err(err).Msg("")
+		return 1
+	}
+
+	age, err := parseAge(*olderThan)
+	if err != nil {
+		log.Error().Err(err).Msg("")
+		return 1
+	}
+	pruned, err := pruneBackups(flags.Args(), time.Now().Add(-age), walk, *dryRun, backup)
+	if err != nil {
+		log.Error().Err(err).Msg("")
+		return 1
+	}
+	log.Info().Msgf("Pruned %d backup(s)", pruned)
+	return 0
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestoreFile(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "[1] a.mp3")
+	assert.NoError(t, os.WriteFile(src, []byte("fixed"), 0640))
+	for _, ts := range []int{100, 300, 200} {
+		assert.NoError(t, os.WriteFile(fmt.Sprintf("%s.%d.bak", src, ts), []byte(fmt.Sprintf("backup %d", ts)), 0644))
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.NoError(t, os.Chtimes(src+".300.bak", mtime, mtime))
+
+	backup, err := restoreFile(src, 0, false, backupOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(300), backup.Time.Unix(), "should restore the latest backup")
+	data, err := os.ReadFile(src)
+	assert.NoError(t, err)
+	assert.Equal(t, "backup 300", string(data))
+	stat, err := os.Stat(src)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), stat.Mode().Perm(), "should take file mode of the backup")
+	assert.True(t, mtime.Equal(stat.ModTime()), "should take modification time of the backup")
+
+	_, err = restoreFile(src, 100, true, backupOptions{})
+	assert.NoError(t, err)
+	data, err = os.ReadFile(src)
+	assert.NoError(t, err)
+	assert.Equal(t, "backup 100", string(data))
+	assert.NoFileExists(t, src+".100.bak", "should delete restored backup")
+
+	_, err = restoreFile(src, 42, false, backupOptions{})
+	assert.EqualError(t, err, fmt.Sprintf("no backup of %s made at 42 found", src))
+
+	assert.NoError(t, os.WriteFile(src+".400.bak", []byte{}, 0644))
+	_, err = restoreFile(src, 0, false, backupOptions{})
+	assert.EqualError(t, err, fmt.Sprintf("backup %s.400.bak is empty", src))
+}
+
+func TestRestoreFile_Checksum(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "a.mp3")
+	assert.NoError(t, os.WriteFile(src, []byte("original"), 0640))
+	opts := backupOptions{}
+	backup, err := opts.makeBackup(src, time.Unix(100, 0), true)
+	assert.NoError(t, err)
+	assert.FileExists(t, checksumPath(backup))
+
+	assert.NoError(t, os.Remove(src))
+	_, err = restoreFile(src, 0, false, opts)
+	assert.NoError(t, err, "should restore a deleted source")
+	data, err := os.ReadFile(src)
+	assert.NoError(t, err)
+	assert.Equal(t, "original", string(data))
+	stat, err := os.Stat(src)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), stat.Mode().Perm(), "should take file mode of the backup")
+
+	assert.NoError(t, os.WriteFile(backup, []byte("damaged!"), 0640))
+	_, err = restoreFile(src, 0, false, opts)
+	assert.EqualError(t, err, fmt.Sprintf("backup %s does not match its checksum", backup))
+
+	assert.NoError(t, os.WriteFile(backup, []byte("original"), 0640))
+	_, err = restoreFile(src, 0, true, opts)
+	assert.NoError(t, err)
+	assert.NoFileExists(t, backup)
+	assert.NoFileExists(t, checksumPath(backup), "should delete the checksum along with the backup")
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "sub", "a.mp3")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(src), 0755))
+	old := fmt.Sprintf("%s.%d.bak", src, time.Now().Add(-48*time.Hour).Unix())
+	recent := fmt.Sprintf("%s.%d.bak", src, time.Now().Unix())
+	for _, name := range []string{src, old, recent} {
+		assert.NoError(t, os.WriteFile(name, []byte("data"), 0644))
+	}
+
+	pruned, err := pruneBackups([]string{dir}, time.Now().Add(-24*time.Hour), walkOptions{recursive: true}, false, backupOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, pruned)
+	assert.NoFileExists(t, old)
+	assert.FileExists(t, recent)
+
+	pruned, err = pruneBackups([]string{src}, time.Now().Add(time.Hour), walkOptions{}, false, backupOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, pruned)
+	assert.NoFileExists(t, recent)
+	assert.FileExists(t, src)
+}
+
+func TestPruneBackups_Template(t *testing.T) {
+	dir := t.TempDir()
+	opts := backupOptions{template: "{name}-{ts}.bak"}
+	assert.NoError(t, opts.validate())
+	backup := filepath.Join(dir, "Album.mp3-100.bak")
+	unrelated := filepath.Join(dir, "Album-1999.mp3")
+	for _, name := range []string{backup, unrelated} {
+		assert.NoError(t, os.WriteFile(name, []byte("data"), 0644))
+	}
+
+	pruned, err := pruneBackups([]string{dir}, time.Now(), walkOptions{recursive: true}, false, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, pruned)
+	assert.NoFileExists(t, backup)
+	assert.FileExists(t, unrelated, "should not prune files, which are not backups")
+}
+
+func TestParseAge(t *testing.T) {
+	age, err := parseAge("30d")
+	assert.NoError(t, err)
+	assert.Equal(t, 30*24*time.Hour, age)
+
+	age, err = parseAge("12h")
+	assert.NoError(t, err)
+	assert.Equal(t, 12*time.Hour, age)
+
+	_, err = parseAge("xd")
+	assert.EqualError(t, err, "invalid age xd")
+}
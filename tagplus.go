@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+
+	id3v1 "github.com/frolovo22/tag"
+)
+
+const tagPlusSize = 227
+
+// size of title, artist and album fields of the enhanced block
+const tagPlusFieldSize = 60
+
+// tagPlus is the enhanced id3v1 block ("TAG+"), which precedes the standard id3v1 tag. Its title, artist and album
+// continue the standard fields, 90 bytes in total
+type tagPlus struct {
+	Title     string
+	Artist    string
+	Album     string
+	Speed     byte   // 0 unset, 1 slow, 2 medium, 3 fast, 4 hardcore
+	Genre     string // free-text genre
+	StartTime string // mmm:ss
+	EndTime   string // mmm:ss
+}
+
+// splitTagPlus cuts the enhanced block off the end of id3v1 file data. Returns nil if there is none
+func splitTagPlus(file *id3v1.ID3v1) *tagPlus {
+	n := len(file.Data) - tagPlusSize
+	if n < 0 || !bytes.HasPrefix(file.Data[n:], []byte("TAG+")) {
+		return nil
+	}
+	raw := file.Data[n:]
+	file.Data = file.Data[:n]
+	return &tagPlus{
+		Title:     beforeZero(raw[4:64]),
+		Artist:    beforeZero(raw[64:124]),
+		Album:     beforeZero(raw[124:184]),
+		Speed:     raw[184],
+		Genre:     beforeZero(raw[185:215]),
+		StartTime: beforeZero(raw[215:221]),
+		EndTime:   beforeZero(raw[221:227]),
+	}
+}
+
+// encodeTagPlus encodes the enhanced id3v1 block, fields are written as raw bytes like in encodeTagV1
+func encodeTagPlus(ext *tagPlus) []byte {
+	data := make([]byte, tagPlusSize)
+	copy(data[0:4], "TAG+")
+	copy(data[4:64], ext.Title)
+	copy(data[64:124], ext.Artist)
+	copy(data[124:184], ext.Album)
+	data[184] = ext.Speed
+	copy(data[185:215], ext.Genre)
+	copy(data[215:221], ext.StartTime)
+	copy(data[221:227], ext.EndTime)
+	return data
+}
+
+// beforeZero returns a zero padded string field
+func beforeZero(b []byte) string {
+	s, _, _ := bytes.Cut(b, []byte{0})
+	return string(s)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	translit "github.com/essentialkaos/translit/v3"
+	"golang.org/x/text/unicode/norm"
+)
+
+// translitScheme is a way to write cyrillic text in latin letters
+type translitScheme struct {
+	Name     string
+	Translit func(string) string
+}
+
+// registry of supported transliteration schemes, the first one is the default
+var translitSchemes = []translitScheme{
+	{"icao", translit.ICAO},
+	{"scientific", translit.Scientific},
+	{"iso9a", translit.ISO9A}, // GOST 7.79-2000 system A
+	{"iso9b", translit.ISO9B}, // GOST 7.79-2000 system B
+	{"bgn", translit.BGN},
+	{"pcgn", translit.PCGN},
+	{"alalc", translit.ALALC},
+	{"bs", translit.BS},
+	{"uk", ukrainianTranslit},
+	{"be", belarusianTranslit},
+}
+
+// lookupTranslitScheme finds a transliteration scheme in the registry by its name
+func lookupTranslitScheme(name string) (translitScheme, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, scheme := range translitSchemes {
+		if scheme.Name == name {
+			return scheme, nil
+		}
+	}
+	return translitScheme{}, fmt.Errorf("transliteration scheme %s not supported", name)
+}
+
+// translitSchemeNames lists names of all supported transliteration schemes
+func translitSchemeNames() []string {
+	res := make([]string, 0, len(translitSchemes))
+	for _, scheme := range translitSchemes {
+		res = append(res, scheme.Name)
+	}
+	return res
+}
+
+// transliterator converts cyrillic text into ascii for id3v1 tags. The zero value uses the default scheme
+type transliterator struct {
+	scheme    translitScheme
+	overrides map[rune]string // letters mapped by the user, they take precedence over the scheme
+}
+
+// Translit transliterates text and folds what is left out of ascii, as id3v1 tags have no encoding
+func (t transliterator) Translit(s string) string {
+	scheme := t.scheme
+	if scheme.Translit == nil {
+		scheme = translitSchemes[0]
+	}
+	if len(t.overrides) > 0 {
+		res := strings.Builder{}
+		for _, r := range s {
+			if v, ok := t.overrides[r]; ok {
+				res.WriteString(v)
+			} else {
+				res.WriteRune(r)
+			}
+		}
+		s = res.String()
+	}
+	return asciiFold(scheme.Translit(s))
+}
+
+// loadTranslitMap reads a user transliteration table: one "letter=replacement" pair per line, the replacement
+// may be empty. Empty lines and lines starting with # are skipped
+func loadTranslitMap(fileName string) (map[rune]string, error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	res := make(map[rune]string)
+	scanner := bufio.NewScanner(fh)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		letter, replacement, ok := strings.Cut(line, "=")
+		letter = strings.TrimSpace(letter)
+		if !ok || utf8.RuneCountInString(letter) != 1 {
+			return nil, fmt.Errorf("%s:%d: expected letter=replacement, got %q", fileName, n, line)
+		}
+		r, _ := utf8.DecodeRuneInString(letter)
+		res[r] = strings.TrimSpace(replacement)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// replacements of non-ascii letters and punctuation, which can not be folded by stripping diacritics.
+// Ukrainian and belarusian letters are left by schemes designed for russian only
+var asciiFoldMap = map[rune]string{
+	'Č': "Ch", 'č': "ch", 'Š': "Sh", 'š': "sh", 'Ž': "Zh", 'ž': "zh",
+	'І': "I", 'і': "i", 'Ї': "I", 'ї': "i", 'Є': "Ie", 'є': "ie", 'Ґ': "G", 'ґ': "g", 'Ў': "U", 'ў': "u",
+	'″': "\"", '′': "'", '«': "\"", '»': "\"", '„': "\"", '“': "\"", '”': "\"", '‘': "'", '’': "'",
+	'–': "-", '—': "-", '…': "...", '№': "No", ' ': " ",
+}
+
+// asciiFold replaces non-ascii runes with their closest ascii counterparts, "?" if there are none
+func asciiFold(s string) string {
+	res := strings.Builder{}
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			res.WriteRune(r)
+			continue
+		}
+		if v, ok := asciiFoldMap[r]; ok {
+			res.WriteString(v)
+			continue
+		}
+		folded := ""
+		for _, d := range norm.NFD.String(string(r)) {
+			if d < utf8.RuneSelf {
+				folded += string(d)
+			} else if !unicode.Is(unicode.Mn, d) {
+				folded = "?"
+				break
+			}
+		}
+		if folded == "" && !unicode.Is(unicode.Mn, r) {
+			folded = "?"
+		}
+		res.WriteString(folded)
+	}
+	return res.String()
+}
+
+var ukrainianMap = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "h", 'ґ': "g", 'д': "d", 'е': "e", 'є': "ie", 'ж': "zh", 'з': "z",
+	'и': "y", 'і': "i", 'ї': "i", 'й': "i", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p",
+	'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh",
+	'щ': "shch", 'ь': "", 'ю': "iu", 'я': "ia",
+}
+
+var ukrainianInitialMap = map[rune]string{'є': "ye", 'ї': "yi", 'й': "y", 'ю': "yu", 'я': "ya"}
+
+// ukrainianTranslit is the ukrainian national transliteration (Cabinet of Ministers resolution 55 of 2010)
+func ukrainianTranslit(s string) string {
+	runes := []rune(s)
+	res := strings.Builder{}
+	for i, r := range runes {
+		lower := unicode.ToLower(r)
+		v, ok := ukrainianMap[lower]
+		switch {
+		case isApostrophe(r) && betweenLetters(runes, i):
+			continue
+		case !ok:
+			res.WriteRune(r)
+			continue
+		case wordStart(runes, i) && ukrainianInitialMap[lower] != "":
+			v = ukrainianInitialMap[lower]
+		case lower == 'г' && i > 0 && unicode.ToLower(runes[i-1]) == 'з':
+			v = "gh"
+		}
+		res.WriteString(withCase(v, runes, i))
+	}
+	return res.String()
+}
+
+// х is kh instead of ch of the standard, as the output is folded into ascii, where č becomes ch as well
+var belarusianMap = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "h", 'ґ': "g", 'д': "d", 'е': "ie", 'ё': "io", 'ж': "ž", 'з': "z",
+	'і': "i", 'й': "j", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s",
+	'т': "t", 'у': "u", 'ў': "ŭ", 'ф': "f", 'х': "kh", 'ц': "c", 'ч': "č", 'ш': "š", 'ы': "y", 'ь': "",
+	'э': "e", 'ю': "iu", 'я': "ia",
+}
+
+var belarusianIotatedMap = map[rune]string{'е': "je", 'ё': "jo", 'ю': "ju", 'я': "ja"}
+
+// consonants written with an acute accent when followed by the soft sign
+var belarusianSoftMap = map[rune]string{'з': "ź", 'л': "ĺ", 'н': "ń", 'с': "ś", 'ц': "ć"}
+
+// belarusianTranslit is the belarusian national transliteration (State Committee on Land Resources
+// instruction of 2007)
+func belarusianTranslit(s string) string {
+	runes := []rune(s)
+	res := strings.Builder{}
+	for i, r := range runes {
+		lower := unicode.ToLower(r)
+		v, ok := belarusianMap[lower]
+		switch {
+		case isApostrophe(r) && betweenLetters(runes, i):
+			continue
+		case !ok:
+			res.WriteRune(r)
+			continue
+		case belarusianIotatedMap[lower] != "" && (wordStart(runes, i) || strings.ContainsRune("аеёіоуыэюяўь'’ʼ", unicode.ToLower(runes[i-1]))):
+			v = belarusianIotatedMap[lower]
+		case belarusianSoftMap[lower] != "" && i+1 < len(runes) && unicode.ToLower(runes[i+1]) == 'ь':
+			v = belarusianSoftMap[lower]
+		}
+		res.WriteString(withCase(v, runes, i))
+	}
+	return res.String()
+}
+
+func isApostrophe(r rune) bool {
+	return r == '\'' || r == '’' || r == 'ʼ'
+}
+
+func betweenLetters(runes []rune, i int) bool {
+	return i > 0 && i+1 < len(runes) && unicode.IsLetter(runes[i-1]) && unicode.IsLetter(runes[i+1])
+}
+
+// wordStart checks if i-th rune starts a word, apostrophes do not break words
+func wordStart(runes []rune, i int) bool {
+	return i == 0 || !unicode.IsLetter(runes[i-1]) && !isApostrophe(runes[i-1])
+}
+
+// withCase applies the case of i-th rune to its transliteration: a capital letter followed by another capital
+// one is transliterated in upper case, otherwise only the first letter is capitalised
+func withCase(v string, runes []rune, i int) string {
+	if !unicode.IsUpper(runes[i]) || v == "" {
+		return v
+	}
+	if i+1 < len(runes) && unicode.IsUpper(runes[i+1]) || i > 0 && unicode.IsUpper(runes[i-1]) {
+		return strings.ToUpper(v)
+	}
+	first, size := utf8.DecodeRuneInString(v)
+	return string(unicode.ToUpper(first)) + v[size:]
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransliterator(t *testing.T) {
+	for _, tc := range []struct {
+		scheme   string
+		text     string
+		expected string
+	}{
+		{"icao", "Аркадий Стругацкий", "Arkadii Strugatskii"},
+		{"iso9b", "Щука и ёж", "Shhuka i yozh"},
+		{"scientific", "Жук", "Zhuk"},
+		{"alalc", "Царь", "Tsar'"},
+		{"uk", "Юрій Андрухович", "Yurii Andrukhovych"},
+		{"uk", "Згорани, Знам'янка, ЗГУРІВКА", "Zghorany, Znamianka, ZGHURIVKA"},
+		{"uk", "Їжакевич, Яготин, Стрий", "Yizhakevych, Yahotyn, Stryi"},
+		{"be", "Васіль Быкаў", "Vasil Bykau"},
+		{"be", "Шклоў, Ельня, Мазыр'е", "Shklou, Jelnia, Mazyrje"},
+		{"be", "Хатынь, Чачэрск", "Khatyn, Chachersk"},
+		{"be", "Любань, Сям'я", "Liuban, Siamja"},
+		{"iso9a", "Чех и хек", "Cheh i hek"},
+		{"icao", "Тарас Шевченко «Кобзар» № 1", "Taras Shevchenko \"Kobzar\" No 1"},
+	} {
+		scheme, err := lookupTranslitScheme(tc.scheme)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, transliterator{scheme: scheme}.Translit(tc.text), tc.scheme)
+	}
+
+	assert.Equal(t, "Arkadii", transliterator{}.Translit("Аркадий"), "should use icao by default")
+
+	_, err := lookupTranslitScheme("klingon")
+	assert.EqualError(t, err, "transliteration scheme klingon not supported")
+}
+
+func TestLoadTranslitMap(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "translit.txt")
+	assert.NoError(t, os.WriteFile(name, []byte("# custom\nй = y\nЙ=Y\n\nь=\n"), 0644))
+	overrides, err := loadTranslitMap(name)
+	assert.NoError(t, err)
+	assert.Equal(t, map[rune]string{'й': "y", 'Й': "Y", 'ь': ""}, overrides)
+
+	tr := transliterator{overrides: overrides}
+	assert.Equal(t, "Arkadiy Strugatskiy", tr.Translit("Аркадий Стругацкий"))
+
+	assert.NoError(t, os.WriteFile(name, []byte("й=y\nab=c\n"), 0644))
+	_, err = loadTranslitMap(name)
+	assert.ErrorContains(t, err, "translit.txt:2: expected letter=replacement")
+}
+
+func TestAsciiFold(t *testing.T) {
+	assert.Equal(t, "Sh\"e'C?", asciiFold("Š″ë′Ĉ☺"))
+}
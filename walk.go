@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// walkOptions controls which files are picked while walking directories
+type walkOptions struct {
+	recursive      bool
+	include        []string // glob patterns, matched against a base name or a relative path
+	exclude        []string // glob patterns, matched against a base name or a relative path
+	extensions     []string // file extensions, case-insensitive, leading dots are optional
+	followSymlinks bool
+}
+
+// collectSources expands directories given in paths into a list of files to fix.
+// Plain files are always taken as is, directories are walked only in recursive mode
+func collectSources(paths []string, opts walkOptions) ([]string, error) {
+	if !opts.recursive {
+		return paths, nil
+	}
+	sources := []string{}
+	for _, p := range paths {
+		stat, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed accessing %s: %w", p, err)
+		}
+		if !stat.IsDir() {
+			sources = append(sources, p)
+			continue
+		}
+		visited := make(map[string]bool)
+		found, err := walkDir(p, p, opts, visited)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, found...)
+	}
+	log.Debug().Msgf("Found %d file(s) to fix", len(sources))
+
+	return sources, nil
+}
+
+func walkDir(root, dir string, opts walkOptions, visited map[string]bool) ([]string, error) {
+	// guard against symlink loops
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed resolving %s: %w", dir, err)
+	}
+	if visited[realDir] {
+		log.Debug().Msgf("Skipping already visited directory %s", dir)
+		return nil, nil
+	}
+	visited[realDir] = true
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading directory %s: %w", dir, err)
+	}
+	sources := []string{}
+	for _, entry := range entries {
+		name := filepath.Join(dir, entry.Name())
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			rel = name
+		}
+		if matchAny(opts.exclude, entry.Name(), rel) {
+			log.Debug().Msgf("Skipping excluded %s", name)
+			continue
+		}
+
+		mode := entry.Type()
+		if mode&fs.ModeSymlink != 0 {
+			if !opts.followSymlinks {
+				log.Debug().Msgf("Skipping symlink %s", name)
+				continue
+			}
+			stat, err := os.Stat(name)
+			if err != nil {
+				log.Warn().Err(err).Msgf("Skipping broken symlink %s", name)
+				continue
+			}
+			mode = stat.Mode().Type()
+		}
+
+		if mode.IsDir() {
+			found, err := walkDir(root, name, opts, visited)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, found...)
+			continue
+		}
+		if !mode.IsRegular() {
+			continue
+		}
+		if !hasExtension(entry.Name(), opts.extensions) {
+			continue
+		}
+		if len(opts.include) > 0 && !matchAny(opts.include, entry.Name(), rel) {
+			continue
+		}
+		sources = append(sources, name)
+	}
+
+	return sources, nil
+}
+
+// matchAny checks if any of patterns matches either a base name or a relative path
+func matchAny(patterns []string, name, rel string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hasExtension checks if a file name has one of the extensions, the leading dot of an extension is optional
+func hasExtension(name string, extensions []string) bool {
+	if len(extensions) == 0 {
+		return true
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range extensions {
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		if ext == strings.ToLower(e) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectSources(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a.mp3", "b.MP3", "c.txt", "sub/d.mp3", "sub/drafts/e.mp3", "other/f.mp3"} {
+		name = filepath.Join(root, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+		assert.NoError(t, os.WriteFile(name, []byte{}, 0644))
+	}
+	assert.NoError(t, os.Symlink(filepath.Join(root, "sub", "drafts"), filepath.Join(root, "other", "link")))
+	assert.NoError(t, os.Symlink(root, filepath.Join(root, "sub", "loop")))
+
+	opts := walkOptions{recursive: true, extensions: []string{".mp3"}}
+	actual, err := collectSources([]string{root}, opts)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(root, "a.mp3"),
+		filepath.Join(root, "b.MP3"),
+		filepath.Join(root, "sub/d.mp3"),
+		filepath.Join(root, "sub/drafts/e.mp3"),
+		filepath.Join(root, "other/f.mp3"),
+	}, actual, "should skip symlinks and other extensions")
+
+	opts.exclude = []string{"drafts", "b.*"}
+	opts.followSymlinks = true
+	actual, err = collectSources([]string{root}, opts)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(root, "a.mp3"),
+		filepath.Join(root, "sub/d.mp3"),
+		filepath.Join(root, "other/f.mp3"),
+		filepath.Join(root, "other/link/e.mp3"),
+	}, actual, "should follow symlinks without loops and skip excluded")
+
+	opts = walkOptions{recursive: true, extensions: []string{".mp3"}, include: []string{"sub/*"}}
+	actual, err = collectSources([]string{root}, opts)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{filepath.Join(root, "sub/d.mp3")}, actual, "should match relative paths")
+
+	actual, err = collectSources([]string{"x.mp3", root}, walkOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"x.mp3", root}, actual, "should not walk in non-recursive mode")
+}
+
+func TestHasExtension(t *testing.T) {
+	assert.True(t, hasExtension("a.mp3", nil))
+	assert.True(t, hasExtension("a.mp3", []string{".mp3"}))
+	assert.True(t, hasExtension("a.MP3", []string{"mp3"}), "should add a missing dot")
+	assert.True(t, hasExtension("a.mp3", []string{"flac", "MP3"}))
+	assert.False(t, hasExtension("a.mp3", []string{"p3"}))
+	assert.False(t, hasExtension("amp3", []string{"mp3"}))
+}